@@ -0,0 +1,105 @@
+// Package access decide quién puede ver los datos clínicos de un paciente según su relación con él:
+// el propio paciente siempre; un médico con una cita aceptada o una consulta con el paciente, y una
+// enfermera asignada (por el médico o un administrador) a una de sus consultas, durante
+// CARE_ACCESS_DAYS días desde el último encuentro.
+package access
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	RelationOwner  = "titular"
+	RelationDoctor = "medico_tratante"
+	RelationNurse  = "enfermera_asignada"
+	// RelationService identifica el acceso de un sistema externo con clave de API
+	RelationService = "integracion"
+	// RelationEmergency es un acceso de emergencia vigente (break_glass_access), solo al expediente
+	RelationEmergency = "acceso_de_emergencia"
+)
+
+// Grant es el resultado de evaluar el acceso. Until es nil cuando el acceso no caduca.
+type Grant struct {
+	Allowed  bool
+	Relation string
+	Until    *time.Time
+}
+
+// careWindow es el tiempo que el equipo de atención conserva el acceso tras el último encuentro.
+func careWindow() time.Duration {
+	return time.Duration(utils.GetEnvInt("CARE_ACCESS_DAYS", 30)) * 24 * time.Hour
+}
+
+// Las citas futuras aceptadas también cuentan: el médico prepara la visita con el expediente.
+// Cada encuentro se limita a now() (dentro de max, porque least ignora los NULL y sin encuentros
+// devolvería now()), así que una fecha lejana no alarga el acceso más allá de CARE_ACCESS_DAYS desde hoy.
+const doctorLastEncounterSQL = `SELECT max(encuentro) FROM (
+	SELECT least(c.fecha_hora::timestamptz, now()) AS encuentro FROM citas c
+	JOIN medicos m ON m.id_medico = c.id_medico
+	WHERE m.id_usuario = $1 AND c.id_paciente = $2 AND c.estado = 'aceptada'
+	UNION ALL
+	SELECT least(co.fecha_hora::timestamptz, now()) FROM consultas co
+	JOIN medicos m ON m.id_medico = co.id_medico
+	WHERE m.id_usuario = $1 AND co.id_paciente = $2
+) encuentros`
+
+const nurseLastEncounterSQL = `SELECT max(least(co.fecha_hora::timestamptz, now())) FROM consultas co
+	JOIN enfermeras e ON e.id_enfermera = co.id_enfermera
+	WHERE e.id_usuario = $1 AND co.id_paciente = $2`
+
+// PatientAccess evalúa si el usuario autenticado (userID, role) puede ver los datos del paciente idPaciente.
+func PatientAccess(ctx context.Context, userID int, role string, idPaciente int) (Grant, error) {
+	switch role {
+	case "Paciente":
+		var owner bool
+		err := config.Conn.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM pacientes WHERE id_paciente = $1 AND id_usuario = $2)",
+			idPaciente, userID).Scan(&owner)
+		if err != nil {
+			return Grant{}, err
+		}
+		return Grant{Allowed: owner, Relation: RelationOwner}, nil
+	case "Medico":
+		return lastEncounterGrant(ctx, doctorLastEncounterSQL, RelationDoctor, userID, idPaciente)
+	case "Enfermero":
+		return lastEncounterGrant(ctx, nurseLastEncounterSQL, RelationNurse, userID, idPaciente)
+	}
+	return Grant{}, nil
+}
+
+func lastEncounterGrant(ctx context.Context, query, relation string, userID, idPaciente int) (Grant, error) {
+	var last *time.Time
+	err := config.Conn.QueryRow(ctx, query, userID, idPaciente).Scan(&last)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && last == nil) {
+		return Grant{Relation: relation}, nil
+	}
+	if err != nil {
+		return Grant{}, err
+	}
+	until := last.Add(careWindow())
+	return Grant{Allowed: time.Now().Before(until), Relation: relation, Until: &until}, nil
+}
+
+// EmergencyAccess busca un acceso de emergencia vigente de userID al paciente idPaciente.
+// Caduca solo: deja de contar al pasar expires_at.
+func EmergencyAccess(ctx context.Context, userID, idPaciente int) (Grant, error) {
+	var until time.Time
+	err := config.Conn.QueryRow(ctx,
+		`SELECT max(expires_at) FROM break_glass_access
+		WHERE id_usuario = $1 AND id_paciente = $2 AND expires_at > now()
+		HAVING max(expires_at) IS NOT NULL`,
+		userID, idPaciente).Scan(&until)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Grant{Relation: RelationEmergency}, nil
+	}
+	if err != nil {
+		return Grant{}, err
+	}
+	return Grant{Allowed: true, Relation: RelationEmergency, Until: &until}, nil
+}
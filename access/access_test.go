@@ -0,0 +1,146 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+)
+
+// fakeDB responde a QueryRow con row (o err) y guarda la última consulta recibida.
+type fakeDB struct {
+	row     []interface{}
+	err     error
+	queries []string
+}
+
+func (db *fakeDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return nil, errors.New("Exec no esperado")
+}
+func (db *fakeDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("Query no esperado")
+}
+func (db *fakeDB) QueryRow(_ context.Context, sql string, _ ...interface{}) pgx.Row {
+	db.queries = append(db.queries, sql)
+	return fakeRow{db.row, db.err}
+}
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) { return nil, errors.New("Begin no esperado") }
+func (db *fakeDB) Close()                                {}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.values == nil {
+		return pgx.ErrNoRows
+	}
+	for i, v := range r.values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func useFakeDB(t *testing.T, db *fakeDB) {
+	t.Helper()
+	prev := config.Conn
+	config.Conn = db
+	t.Cleanup(func() { config.Conn = prev })
+}
+
+func TestPatientAccessOwner(t *testing.T) {
+	for _, owner := range []bool{true, false} {
+		useFakeDB(t, &fakeDB{row: []interface{}{owner}})
+		grant, err := PatientAccess(context.Background(), 7, "Paciente", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grant.Allowed != owner || grant.Relation != RelationOwner || grant.Until != nil {
+			t.Errorf("titular=%v: grant = %+v", owner, grant)
+		}
+	}
+}
+
+func TestPatientAccessCareTeam(t *testing.T) {
+	t.Setenv("CARE_ACCESS_DAYS", "30")
+	recent := time.Now().Add(-10 * 24 * time.Hour)
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	for _, tc := range []struct {
+		role     string
+		relation string
+		table    string
+	}{
+		{"Medico", RelationDoctor, "medicos"},
+		{"Enfermero", RelationNurse, "enfermeras"},
+	} {
+		for _, c := range []struct {
+			name string
+			last *time.Time
+			want bool
+		}{
+			{"sin encuentros", nil, false},
+			{"encuentro reciente", &recent, true},
+			{"encuentro fuera de la ventana", &old, false},
+		} {
+			db := &fakeDB{row: []interface{}{c.last}}
+			useFakeDB(t, db)
+			grant, err := PatientAccess(context.Background(), 7, tc.role, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if grant.Allowed != c.want || grant.Relation != tc.relation {
+				t.Errorf("%s, %s: grant = %+v, se esperaba permitido = %v", tc.role, c.name, grant, c.want)
+			}
+			if c.last != nil && (grant.Until == nil || !grant.Until.Equal(c.last.Add(30*24*time.Hour))) {
+				t.Errorf("%s, %s: Until = %v, se esperaba el último encuentro + 30 días", tc.role, c.name, grant.Until)
+			}
+			// Cada encuentro se limita a now() antes del max: una fecha futura no alarga el acceso
+			query := db.queries[0]
+			if !strings.Contains(query, tc.table) || !strings.Contains(query, "least(") || strings.Contains(query, "least(max") {
+				t.Errorf("%s: consulta sin límite por encuentro a now(): %s", tc.role, query)
+			}
+		}
+	}
+}
+
+func TestPatientAccessOtherRolesAndErrors(t *testing.T) {
+	db := &fakeDB{}
+	useFakeDB(t, db)
+	for _, role := range []string{"Administrador", "", "medico"} {
+		grant, err := PatientAccess(context.Background(), 7, role, 3)
+		if err != nil || grant.Allowed {
+			t.Errorf("rol %q: grant = %+v, err = %v", role, grant, err)
+		}
+	}
+	if len(db.queries) != 0 {
+		t.Errorf("roles sin relación no deben consultar la base de datos: %v", db.queries)
+	}
+
+	useFakeDB(t, &fakeDB{err: errors.New("conexión perdida")})
+	if grant, err := PatientAccess(context.Background(), 7, "Medico", 3); err == nil || grant.Allowed {
+		t.Errorf("error de base de datos: grant = %+v, err = %v", grant, err)
+	}
+}
+
+func TestEmergencyAccess(t *testing.T) {
+	useFakeDB(t, &fakeDB{})
+	if grant, err := EmergencyAccess(context.Background(), 7, 3); err != nil || grant.Allowed {
+		t.Errorf("sin acceso vigente: grant = %+v, err = %v", grant, err)
+	}
+	until := time.Now().Add(time.Hour)
+	useFakeDB(t, &fakeDB{row: []interface{}{until}})
+	grant, err := EmergencyAccess(context.Background(), 7, 3)
+	if err != nil || !grant.Allowed || grant.Relation != RelationEmergency || !grant.Until.Equal(until) {
+		t.Errorf("con acceso vigente: grant = %+v, err = %v", grant, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package apikeys gestiona las claves de API de los sistemas externos (laboratorio, facturación).
+// Una clave tiene la forma hsk_<prefijo>_<secreto>: el prefijo identifica la clave en listados y
+// auditoría, y en la base de datos solo se guarda el SHA-256 de la clave completa.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/policy"
+)
+
+const (
+	keyTag    = "hsk_"
+	prefixLen = 8
+)
+
+// ErrInvalid indica una clave inexistente, revocada o expirada.
+var ErrInvalid = errors.New("clave de API inválida, revocada o expirada")
+
+// Key es una clave autenticada.
+type Key struct {
+	ID     int
+	Prefix string
+	Nombre string
+	Scopes []policy.Permission
+}
+
+// Generate crea una clave nueva y devuelve la clave completa (solo se muestra una vez), su prefijo y su hash.
+func Generate() (key, prefix, hash string, err error) {
+	buf := make([]byte, prefixLen/2+32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf[:prefixLen/2])
+	key = keyTag + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[prefixLen/2:])
+	return key, prefix, Hash(key), nil
+}
+
+// Hash devuelve el SHA-256 en hexadecimal de la clave. Basta un hash rápido porque la clave
+// tiene 256 bits de entropía.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// PrefixOf extrae el prefijo de una clave con formato válido, o "" si no lo tiene.
+func PrefixOf(key string) string {
+	if !strings.HasPrefix(key, keyTag) || len(key) < len(keyTag)+prefixLen+2 || key[len(keyTag)+prefixLen] != '_' {
+		return ""
+	}
+	return key[len(keyTag) : len(keyTag)+prefixLen]
+}
+
+// Authenticate valida la clave y registra su último uso.
+func Authenticate(ctx context.Context, key string) (*Key, error) {
+	if PrefixOf(key) == "" {
+		return nil, ErrInvalid
+	}
+	var k Key
+	var scopes []string
+	err := config.Conn.QueryRow(ctx,
+		`UPDATE api_keys SET last_used_at = now()
+		WHERE key_hash = $1 AND revoked_at IS NULL AND expires_at > now()
+		RETURNING id_clave, prefix, nombre, scopes`,
+		Hash(key)).Scan(&k.ID, &k.Prefix, &k.Nombre, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		k.Scopes = append(k.Scopes, policy.Permission(s))
+	}
+	return &k, nil
+}
+
+// Allows indica si la clave tiene el permiso perm entre sus scopes. Un scope que policy ya no
+// permite conceder a claves deja de valer aunque siga guardado.
+func (k *Key) Allows(perm policy.Permission) bool {
+	if !policy.APIKeyScope(perm) {
+		return false
+	}
+	for _, s := range k.Scopes {
+		if s == perm {
+			return true
+		}
+	}
+	return false
+}
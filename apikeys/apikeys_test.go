@@ -0,0 +1,175 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/policy"
+)
+
+type storedKey struct {
+	id        int
+	prefix    string
+	scopes    []string
+	revoked   bool
+	expiresAt time.Time
+	lastUsed  bool
+}
+
+// fakeDB simula la tabla api_keys para el UPDATE ... RETURNING de Authenticate.
+type fakeDB struct {
+	keys map[string]*storedKey // por key_hash
+	err  error
+}
+
+func (db *fakeDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return nil, errors.New("Exec no esperado")
+}
+func (db *fakeDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("Query no esperado")
+}
+func (db *fakeDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	if db.err != nil {
+		return fakeRow{err: db.err}
+	}
+	if !strings.Contains(sql, "revoked_at IS NULL AND expires_at > now()") {
+		return fakeRow{err: errors.New("consulta sin filtrar revocadas y expiradas")}
+	}
+	k, ok := db.keys[args[0].(string)]
+	if !ok || k.revoked || !k.expiresAt.After(time.Now()) {
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	k.lastUsed = true
+	return fakeRow{key: k}
+}
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) { return nil, errors.New("Begin no esperado") }
+func (db *fakeDB) Close()                                {}
+
+type fakeRow struct {
+	key *storedKey
+	err error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = r.key.id
+	*dest[1].(*string) = r.key.prefix
+	*dest[2].(*string) = "Laboratorio"
+	*dest[3].(*[]string) = r.key.scopes
+	return nil
+}
+
+func useFakeDB(t *testing.T, db *fakeDB) {
+	t.Helper()
+	prev := config.Conn
+	config.Conn = db
+	t.Cleanup(func() { config.Conn = prev })
+}
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "hsk_"+prefix+"_") || len(prefix) != prefixLen {
+		t.Fatalf("clave %q con prefijo %q", key, prefix)
+	}
+	if PrefixOf(key) != prefix || hash != Hash(key) || len(hash) != 64 {
+		t.Fatalf("PrefixOf = %q, hash = %q", PrefixOf(key), hash)
+	}
+	other, _, _, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Fatal("dos claves generadas son iguales")
+	}
+}
+
+func TestPrefixOfMalformed(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"hsk_",
+		"hsk_0123abcd",
+		"hsk_0123abcd_",       // sin secreto
+		"hsk_0123abc_secreto", // prefijo corto
+		"hsk-0123abcd_secreto",
+		"xyz_0123abcd_secreto",
+		"Bearer hsk_0123abcd_secreto",
+	} {
+		if got := PrefixOf(key); got != "" {
+			t.Errorf("PrefixOf(%q) = %q, se esperaba vacío", key, got)
+		}
+	}
+	if got := PrefixOf("hsk_0123abcd_s"); got != "0123abcd" {
+		t.Errorf("PrefixOf de una clave válida = %q", got)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	valid, prefix, hash, _ := Generate()
+	revoked, _, revokedHash, _ := Generate()
+	expired, _, expiredHash, _ := Generate()
+	unknown, _, _, _ := Generate()
+	tampered := valid[:len(valid)-1] + "x"
+	if tampered == valid {
+		tampered = valid[:len(valid)-1] + "y"
+	}
+	db := &fakeDB{keys: map[string]*storedKey{
+		hash:        {id: 1, prefix: prefix, scopes: []string{"citas:leer"}, expiresAt: time.Now().Add(time.Hour)},
+		revokedHash: {id: 2, revoked: true, expiresAt: time.Now().Add(time.Hour)},
+		expiredHash: {id: 3, expiresAt: time.Now().Add(-time.Minute)},
+	}}
+	useFakeDB(t, db)
+	ctx := context.Background()
+
+	k, err := Authenticate(ctx, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.ID != 1 || k.Prefix != prefix || len(k.Scopes) != 1 || k.Scopes[0] != policy.CitasLeer {
+		t.Fatalf("clave autenticada = %+v", k)
+	}
+	if !db.keys[hash].lastUsed {
+		t.Error("no se registró el último uso")
+	}
+
+	for name, key := range map[string]string{
+		"revocada":         revoked,
+		"expirada":         expired,
+		"desconocida":      unknown,
+		"mal formada":      "hsk_corta",
+		"secreto alterado": tampered,
+	} {
+		if _, err := Authenticate(ctx, key); !errors.Is(err, ErrInvalid) {
+			t.Errorf("clave %s: err = %v, se esperaba ErrInvalid", name, err)
+		}
+	}
+
+	useFakeDB(t, &fakeDB{err: errors.New("conexión perdida")})
+	if _, err := Authenticate(ctx, valid); err == nil || errors.Is(err, ErrInvalid) {
+		t.Errorf("error de base de datos: err = %v, se esperaba el error original", err)
+	}
+}
+
+func TestKeyAllows(t *testing.T) {
+	k := &Key{Scopes: []policy.Permission{policy.CitasLeer, policy.UsuariosGestionar}}
+	if !k.Allows(policy.CitasLeer) {
+		t.Error("la clave debe permitir su scope citas:leer")
+	}
+	if k.Allows(policy.ExpedientesLeer) {
+		t.Error("la clave no debe permitir un permiso fuera de sus scopes")
+	}
+	// Guardado antes de que policy lo retirara de los scopes concedibles: ya no vale
+	if policy.APIKeyScope(policy.UsuariosGestionar) || k.Allows(policy.UsuariosGestionar) {
+		t.Error("un scope que policy ya no permite a las claves no debe valer aunque siga guardado")
+	}
+}
@@ -5,18 +5,30 @@ import (
 	"log"
 	"os"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-var Conn *pgxpool.Pool
+// DB es la parte de *pgxpool.Pool que usa la aplicación. Las pruebas pueden sustituir Conn
+// por un doble sin base de datos.
+type DB interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Close()
+}
+
+var Conn DB
 
 func InitDatabase() error {
 	connStr := os.Getenv("SUPABASE_CONNECTION_STRING")
-	var err error
-	Conn, err = pgxpool.Connect(context.Background(), connStr)
+	pool, err := pgxpool.Connect(context.Background(), connStr)
 	if err != nil {
 		log.Printf("Error al conectar a la base de datos: %v", err)
 		return err
 	}
+	Conn = pool
 	return nil
 }
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTKeys contiene las claves de firma y verificación de los JWT, cargadas por InitJWTKeys.
+var JWTKeys *KeySet
+
+// KeySet agrupa una clave activa de firma y todas las claves aceptadas para verificar, indexadas por kid.
+type KeySet struct {
+	activeKID string
+	keys      map[string]*jwtKey
+}
+
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// JWK es la representación pública de una clave según RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// InitJWTKeys carga las claves PEM de JWT_KEYS_DIR y selecciona JWT_ACTIVE_KID para firmar.
+// Cada archivo <kid>.pem contiene una clave privada (RSA o Ed25519); los archivos <kid>.pub.pem
+// contienen solo la clave pública y sirven para verificar tokens firmados con claves retiradas.
+func InitJWTKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		dir = "keys"
+	}
+	ks, err := LoadKeySet(dir, os.Getenv("JWT_ACTIVE_KID"))
+	if err != nil {
+		log.Printf("Error al cargar claves JWT: %v", err)
+		return err
+	}
+	JWTKeys = ks
+	return nil
+}
+
+// LoadKeySet lee todas las claves de dir. activeKID debe corresponder a una clave privada.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{activeKID: activeKID, keys: map[string]*jwtKey{}}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(strings.TrimSuffix(name, ".pem"), ".pub")
+		key, err := parseJWTKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		// Si existen <kid>.pem y <kid>.pub.pem, prevalece la clave privada
+		if existing, ok := ks.keys[kid]; ok && existing.private != nil {
+			continue
+		}
+		ks.keys[kid] = key
+	}
+	if activeKID == "" {
+		return nil, errors.New("JWT_ACTIVE_KID no está definido")
+	}
+	active, ok := ks.keys[activeKID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("no hay clave privada para el kid activo %q", activeKID)
+	}
+	return ks, nil
+}
+
+func parseJWTKey(kid string, data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("PEM inválido")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("tipo PEM no soportado: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jwtKey{kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("algoritmo de clave no soportado: %T", parsed)
+	}
+	return key, nil
+}
+
+// Sign firma claims con la clave activa e incluye su kid en la cabecera.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.activeKID]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resuelve la clave de verificación por kid y rechaza cualquier alg distinto al de esa clave.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("kid no encontrado en token")
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("kid desconocido: %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("alg inesperado: %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWKS devuelve las claves públicas de verificación, ordenadas por kid.
+func (ks *KeySet) JWKS() []JWK {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"hospitalaria/mailer"
+)
+
+// Mailer es el servicio de correo elegido con MAIL_DRIVER.
+var Mailer mailer.Sender
+
+// InitMailer configura el envío de correo: MAIL_DRIVER=smtp usa SMTP_HOST, SMTP_PORT,
+// SMTP_USER y SMTP_PASSWORD; "file" (por defecto) escribe en MAIL_DIR; "memory" no envía nada.
+func InitMailer() error {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@hospitalaria.local"
+	}
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		Mailer = &mailer.SMTPSender{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "", "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail_outbox"
+		}
+		Mailer = &mailer.FileSender{Dir: dir, From: from}
+	case "memory":
+		Mailer = &mailer.MemorySender{}
+	default:
+		return fmt.Errorf("MAIL_DRIVER desconocido: %s", driver)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"hospitalaria/oidc"
+)
+
+// OIDC es el proveedor de identidad corporativo; es nil si OIDC_ISSUER no está definido.
+var OIDC *oidc.Provider
+
+// OIDCStaffRequired indica que el personal debe iniciar sesión con el IdP y no con contraseña.
+var OIDCStaffRequired bool
+
+// InitOIDC configura el proveedor a partir de OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL y OIDC_SCOPES (separados por espacios). El descubrimiento se hace en el
+// primer login, así que un IdP caído no impide arrancar. OIDC_STAFF_REQUIRED=false permite
+// que el personal siga usando su contraseña local.
+func InitOIDC() error {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		OIDC = nil
+		OIDCStaffRequired = false
+		return nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return errors.New("OIDC_CLIENT_ID y OIDC_REDIRECT_URL son obligatorios con OIDC_ISSUER")
+	}
+
+	OIDC = oidc.NewProvider(issuer, clientID, os.Getenv("OIDC_CLIENT_SECRET"), redirectURL)
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		OIDC.Scopes = strings.Fields(scopes)
+	}
+	OIDCStaffRequired = os.Getenv("OIDC_STAFF_REQUIRED") != "false"
+	return nil
+}
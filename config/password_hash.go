@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	"hospitalaria/passwordhash"
+	"hospitalaria/utils"
+)
+
+// PasswordHasher genera los hashes de contraseña nuevos; lo configura InitPasswordHasher.
+var PasswordHasher *passwordhash.Hasher
+
+// InitPasswordHasher lee PASSWORD_HASH_ALGORITHM (argon2id o bcrypt), ARGON2_MEMORY_KIB,
+// ARGON2_ITERATIONS, ARGON2_PARALLELISM y BCRYPT_COST. Subir cualquiera de ellos hace que los
+// hashes existentes se actualicen en el siguiente login de cada usuario.
+func InitPasswordHasher() error {
+	algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM")
+	if algorithm == "" {
+		algorithm = passwordhash.Argon2id
+	}
+	if algorithm != passwordhash.Argon2id && algorithm != passwordhash.Bcrypt {
+		return fmt.Errorf("PASSWORD_HASH_ALGORITHM desconocido: %q", algorithm)
+	}
+	memory := utils.GetEnvInt("ARGON2_MEMORY_KIB", 64*1024)
+	iterations := utils.GetEnvInt("ARGON2_ITERATIONS", 3)
+	parallelism := utils.GetEnvInt("ARGON2_PARALLELISM", 2)
+	if memory < 8*1024 || iterations < 1 || parallelism < 1 || parallelism > 255 {
+		return fmt.Errorf("parámetros argon2id inválidos: m=%d t=%d p=%d", memory, iterations, parallelism)
+	}
+	cost := utils.GetEnvInt("BCRYPT_COST", 12)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("BCRYPT_COST fuera de rango: %d", cost)
+	}
+
+	PasswordHasher = &passwordhash.Hasher{
+		Algorithm: algorithm,
+		Argon2: passwordhash.Argon2Params{
+			Memory:      uint32(memory),
+			Iterations:  uint32(iterations),
+			Parallelism: uint8(parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: cost,
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"hospitalaria/passwordhash"
+	"hospitalaria/passwordpolicy"
+	"hospitalaria/utils"
+)
+
+// PasswordPolicy es la política de contraseñas configurada por InitPasswordPolicy.
+var PasswordPolicy *passwordpolicy.Policy
+
+// InitPasswordPolicy arma la política a partir de PASSWORD_MIN_LENGTH, PASSWORD_REQUIRED_CLASSES
+// (lista separada por comas de lower, upper, digit y symbol), PASSWORD_MAX_REPEATED (0 la desactiva)
+// y la lista de contraseñas comunes de PASSWORD_BLOCKLIST_FILE.
+func InitPasswordPolicy() error {
+	minLength := utils.GetEnvInt("PASSWORD_MIN_LENGTH", 12)
+	if minLength <= 0 || minLength > passwordhash.MaxBcryptBytes {
+		return fmt.Errorf("PASSWORD_MIN_LENGTH debe estar entre 1 y %d: %d", passwordhash.MaxBcryptBytes, minLength)
+	}
+	// El máximo se aplica con cualquier algoritmo: así cambiar PASSWORD_HASH_ALGORITHM a bcrypt
+	// no deja contraseñas que ya no puedan hashearse
+	rules := []passwordpolicy.Rule{
+		passwordpolicy.MinLength{Min: minLength},
+		passwordpolicy.MaxLength{Max: passwordhash.MaxBcryptBytes},
+	}
+
+	classes := os.Getenv("PASSWORD_REQUIRED_CLASSES")
+	if classes == "" {
+		classes = "digit,symbol"
+	}
+	var required []passwordpolicy.CharClass
+	for _, name := range strings.Split(classes, ",") {
+		class, err := passwordpolicy.ParseCharClass(name)
+		if err != nil {
+			return err
+		}
+		required = append(required, class)
+	}
+	rules = append(rules, passwordpolicy.CharClasses{Required: required})
+
+	if maxRepeated := utils.GetEnvInt("PASSWORD_MAX_REPEATED", 3); maxRepeated > 0 {
+		rules = append(rules, passwordpolicy.MaxRepeated{Max: maxRepeated})
+	}
+	rules = append(rules, passwordpolicy.NoPersonalInfo{})
+
+	path := os.Getenv("PASSWORD_BLOCKLIST_FILE")
+	if path == "" {
+		path = "data/common-passwords.txt"
+	}
+	blocklist, err := passwordpolicy.LoadBlocklist(path)
+	if err != nil {
+		return err
+	}
+	rules = append(rules, blocklist)
+	log.Printf("Lista de contraseñas comunes cargada: %d entradas", blocklist.Len())
+
+	PasswordPolicy = &passwordpolicy.Policy{Rules: rules}
+	return nil
+}
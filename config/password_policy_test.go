@@ -0,0 +1,27 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitPasswordPolicyMinLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PASSWORD_BLOCKLIST_FILE", path)
+	prev := PasswordPolicy
+	t.Cleanup(func() { PasswordPolicy = prev })
+
+	for _, tc := range []struct {
+		env     string
+		wantErr bool
+	}{{"12", false}, {"1", false}, {"72", false}, {"0", true}, {"-8", true}, {"73", true}} {
+		t.Setenv("PASSWORD_MIN_LENGTH", tc.env)
+		if err := InitPasswordPolicy(); (err != nil) != tc.wantErr {
+			t.Errorf("PASSWORD_MIN_LENGTH=%s: err = %v", tc.env, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ServerConfig arma la configuración de Fiber. Detrás de un proxy inverso, PROXY_HEADER indica la
+// cabecera con la IP del cliente (p. ej. X-Real-IP) y TRUSTED_PROXIES las IPs o rangos CIDR de los
+// proxies, separados por comas. La cabecera solo se lee en peticiones que llegan desde esos proxies:
+// sin ellos cualquiera podría elegir su IP y esquivar el bloqueo por IP del login.
+func ServerConfig() (fiber.Config, error) {
+	header := strings.TrimSpace(os.Getenv("PROXY_HEADER"))
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	if header == "" {
+		if len(proxies) > 0 {
+			return fiber.Config{}, errors.New("TRUSTED_PROXIES requiere PROXY_HEADER")
+		}
+		return fiber.Config{}, nil
+	}
+	if len(proxies) == 0 {
+		return fiber.Config{}, errors.New("PROXY_HEADER requiere TRUSTED_PROXIES")
+	}
+	for _, p := range proxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fiber.Config{}, fmt.Errorf("TRUSTED_PROXIES: %q no es una IP ni un rango CIDR", p)
+			}
+		}
+	}
+	return fiber.Config{
+		ProxyHeader:             header,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          proxies,
+		EnableIPValidation:      true,
+	}, nil
+}
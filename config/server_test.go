@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestServerConfig(t *testing.T) {
+	for _, tc := range []struct {
+		header, proxies string
+		wantErr         bool
+	}{
+		{"", "", false},
+		{"X-Real-IP", "10.0.0.1, 10.1.0.0/16", false},
+		{"X-Real-IP", "", true},
+		{"", "10.0.0.1", true},
+		{"X-Real-IP", "proxy.hospital.local", true},
+	} {
+		t.Setenv("PROXY_HEADER", tc.header)
+		t.Setenv("TRUSTED_PROXIES", tc.proxies)
+		cfg, err := ServerConfig()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("PROXY_HEADER=%q TRUSTED_PROXIES=%q: err = %v", tc.header, tc.proxies, err)
+			continue
+		}
+		if err == nil && cfg.ProxyHeader != tc.header {
+			t.Errorf("ProxyHeader = %q, se esperaba %q", cfg.ProxyHeader, tc.header)
+		}
+		if tc.header != "" && err == nil && (!cfg.EnableTrustedProxyCheck || len(cfg.TrustedProxies) != 2) {
+			t.Errorf("proxies de confianza no configurados: %+v", cfg)
+		}
+	}
+}
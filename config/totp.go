@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/pquerna/otp"
+	"hospitalaria/utils"
+)
+
+// maxTOTPSkew limita la ventana de deriva: cada paso de tolerancia añade dos códigos válidos más.
+const maxTOTPSkew = 5
+
+// TOTPParams son los parámetros TOTP compartidos por la inscripción y la verificación.
+type TOTPParams struct {
+	Period uint
+	Digits otp.Digits
+	Skew   uint
+}
+
+// TOTP la configura InitTOTP al arrancar.
+var TOTP TOTPParams
+
+// InitTOTP lee y valida TOTP_PERIOD, TOTP_DIGITS y TOTP_SKEW. Un valor fuera de rango impide
+// arrancar: un periodo de cero dividiría por cero al verificar y los negativos desbordarían.
+func InitTOTP() error {
+	period := utils.GetEnvInt("TOTP_PERIOD", 30)
+	digits := utils.GetEnvInt("TOTP_DIGITS", 6)
+	skew := utils.GetEnvInt("TOTP_SKEW", 1)
+	if period <= 0 {
+		return fmt.Errorf("TOTP_PERIOD debe ser mayor que cero: %d", period)
+	}
+	if digits != 6 && digits != 8 {
+		return fmt.Errorf("TOTP_DIGITS debe ser 6 u 8: %d", digits)
+	}
+	if skew < 0 || skew > maxTOTPSkew {
+		return fmt.Errorf("TOTP_SKEW debe estar entre 0 y %d: %d", maxTOTPSkew, skew)
+	}
+
+	TOTP = TOTPParams{Period: uint(period), Digits: otp.DigitsSix, Skew: uint(skew)}
+	if digits == 8 {
+		TOTP.Digits = otp.DigitsEight
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"hospitalaria/secretbox"
+)
+
+// TOTPSecrets cifra los secretos TOTP guardados en usuarios; lo configura InitTOTPEncryption.
+var TOTPSecrets *secretbox.Keyring
+
+// InitTOTPEncryption carga TOTP_ENCRYPTION_KEYS, una lista "kid:clave_base64" separada por comas
+// con claves AES de 32 bytes, y cifra con TOTP_ENCRYPTION_ACTIVE_KID. Las claves anteriores
+// deben seguir en la lista hasta que todos los secretos se hayan vuelto a cifrar.
+func InitTOTPEncryption() error {
+	list := os.Getenv("TOTP_ENCRYPTION_KEYS")
+	if list == "" {
+		return errors.New("TOTP_ENCRYPTION_KEYS no está definido")
+	}
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(list, ",") {
+		kid, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return fmt.Errorf("entrada de TOTP_ENCRYPTION_KEYS inválida: se esperaba kid:clave")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("clave %q de TOTP_ENCRYPTION_KEYS no es base64 válido: %w", kid, err)
+		}
+		keys[kid] = key
+	}
+
+	ring, err := secretbox.NewKeyring(keys, os.Getenv("TOTP_ENCRYPTION_ACTIVE_KID"))
+	if err != nil {
+		return err
+	}
+	TOTPSecrets = ring
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthn es la configuración del Relying Party para llaves de seguridad y passkeys.
+var WebAuthn *webauthn.WebAuthn
+
+// InitWebAuthn configura el Relying Party a partir de WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME y
+// WEBAUTHN_RP_ORIGINS (orígenes permitidos separados por comas).
+func InitWebAuthn() error {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "Hospitalaria"
+	}
+	origins := []string{"http://localhost:3000"}
+	if env := os.Getenv("WEBAUTHN_RP_ORIGINS"); env != "" {
+		origins = strings.Split(env, ",")
+	}
+
+	var err error
+	WebAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		log.Printf("Error al configurar WebAuthn: %v", err)
+		return err
+	}
+	return nil
+}
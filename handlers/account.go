@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/utils"
+)
+
+// clinicalRetentionYears es el plazo de conservación de los datos clínicos tras cerrar la cuenta
+// (la NOM-004-SSA3-2012 exige al menos cinco años para el expediente clínico).
+func clinicalRetentionYears() int {
+	return utils.GetEnvInt("CLINICAL_RETENTION_YEARS", 5)
+}
+
+type exportCita struct {
+	IDCita    int    `json:"id_cita"`
+	FechaHora string `json:"fecha_hora"`
+	Estado    string `json:"estado"`
+	Motivo    string `json:"motivo"`
+	Medico    string `json:"medico"`
+}
+
+type exportExpediente struct {
+	IDExpediente        int    `json:"id_expediente"`
+	AntecedentesMedicos string `json:"antecedentes_medicos"`
+	Alergias            string `json:"alergias"`
+	Tratamientos        string `json:"tratamientos"`
+	FechaActualizacion  string `json:"fecha_actualizacion"`
+}
+
+type exportConsulta struct {
+	IDConsulta  int    `json:"id_consulta"`
+	IDCita      int    `json:"id_cita"`
+	FechaHora   string `json:"fecha_hora"`
+	Diagnostico string `json:"diagnostico"`
+	Estado      string `json:"estado"`
+	Medico      string `json:"medico"`
+	Enfermera   string `json:"enfermera"`
+}
+
+// accountExport es el contenido de datos.json en el archivo de exportación.
+type accountExport struct {
+	GeneradoAt  time.Time          `json:"generado_at"`
+	Perfil      *profile           `json:"perfil"`
+	Citas       []exportCita       `json:"citas"`
+	Expedientes []exportExpediente `json:"expedientes"`
+	Consultas   []exportConsulta   `json:"consultas"`
+	Auditoria   []utils.LogEntry   `json:"auditoria"`
+}
+
+// collectAccountData reúne todo lo que guardamos de userID: perfil, datos clínicos si es paciente
+// y sus entradas del registro de auditoría.
+func collectAccountData(ctx context.Context, userID int) (*accountExport, error) {
+	data := &accountExport{
+		GeneradoAt:  time.Now(),
+		Citas:       []exportCita{},
+		Expedientes: []exportExpediente{},
+		Consultas:   []exportConsulta{},
+	}
+	var err error
+	if data.Perfil, err = loadProfile(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Auditoria, err = utils.UserLogEntries(userID); err != nil {
+		return nil, err
+	}
+	if data.Auditoria == nil {
+		data.Auditoria = []utils.LogEntry{}
+	}
+
+	var idPaciente int
+	err = config.Conn.QueryRow(ctx, "SELECT id_paciente FROM pacientes WHERE id_usuario = $1", userID).Scan(&idPaciente)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := config.Conn.Query(ctx,
+		`SELECT c.id_cita, c.fecha_hora::text, c.estado, COALESCE(c.motivo, ''), COALESCE(u.nombre || ' ' || u.apellido, '')
+		FROM citas c
+		LEFT JOIN medicos m ON m.id_medico = c.id_medico
+		LEFT JOIN usuarios u ON u.id_usuario = m.id_usuario
+		WHERE c.id_paciente = $1 ORDER BY c.fecha_hora`, idPaciente)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ci exportCita
+		if err := rows.Scan(&ci.IDCita, &ci.FechaHora, &ci.Estado, &ci.Motivo, &ci.Medico); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Citas = append(data.Citas, ci)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = config.Conn.Query(ctx,
+		`SELECT id_expediente, COALESCE(antecedentes_medicos, ''), COALESCE(alergias, ''), COALESCE(tratamientos, ''),
+			COALESCE(fecha_actualizacion::text, '')
+		FROM expedientes WHERE id_paciente = $1 ORDER BY id_expediente`, idPaciente)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var e exportExpediente
+		if err := rows.Scan(&e.IDExpediente, &e.AntecedentesMedicos, &e.Alergias, &e.Tratamientos, &e.FechaActualizacion); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		data.Expedientes = append(data.Expedientes, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = config.Conn.Query(ctx,
+		`SELECT co.id_consulta, co.id_cita, co.fecha_hora::text, COALESCE(co.diagnostico, ''), co.estado,
+			COALESCE(um.nombre || ' ' || um.apellido, ''), COALESCE(ue.nombre || ' ' || ue.apellido, '')
+		FROM consultas co
+		LEFT JOIN medicos m ON m.id_medico = co.id_medico
+		LEFT JOIN usuarios um ON um.id_usuario = m.id_usuario
+		LEFT JOIN enfermeras e ON e.id_enfermera = co.id_enfermera
+		LEFT JOIN usuarios ue ON ue.id_usuario = e.id_usuario
+		WHERE co.id_paciente = $1 ORDER BY co.fecha_hora`, idPaciente)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var co exportConsulta
+		if err := rows.Scan(&co.IDConsulta, &co.IDCita, &co.FechaHora, &co.Diagnostico, &co.Estado, &co.Medico, &co.Enfermera); err != nil {
+			return nil, err
+		}
+		data.Consultas = append(data.Consultas, co)
+	}
+	return data, rows.Err()
+}
+
+var accountExportHTML = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><title>Mis datos - Hospitalaria</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse;margin-bottom:2em}td,th{border:1px solid #999;padding:4px 8px;text-align:left;vertical-align:top}</style>
+</head>
+<body>
+<h1>Mis datos</h1>
+<p>Generado el {{.GeneradoAt.Format "02/01/2006 15:04"}}. El archivo datos.json contiene la misma información en formato legible por máquina.</p>
+
+<h2>Perfil</h2>
+<table>
+<tr><th>Nombre</th><td>{{.Perfil.Nombre}} {{.Perfil.Apellido}}</td></tr>
+<tr><th>Correo</th><td>{{.Perfil.Correo}}{{if not .Perfil.CorreoVerificado}} (sin verificar){{end}}</td></tr>
+<tr><th>Rol</th><td>{{.Perfil.Rol}}</td></tr>
+{{with .Perfil.FechaNacimiento}}<tr><th>Fecha de nacimiento</th><td>{{.}}</td></tr>{{end}}
+{{with .Perfil.Genero}}<tr><th>Género</th><td>{{.}}</td></tr>{{end}}
+{{with .Perfil.Direccion}}<tr><th>Dirección</th><td>{{.}}</td></tr>{{end}}
+</table>
+
+<h2>Citas</h2>
+{{if .Citas}}<table>
+<tr><th>Fecha</th><th>Médico</th><th>Motivo</th><th>Estado</th></tr>
+{{range .Citas}}<tr><td>{{.FechaHora}}</td><td>{{.Medico}}</td><td>{{.Motivo}}</td><td>{{.Estado}}</td></tr>
+{{end}}</table>{{else}}<p>Sin citas.</p>{{end}}
+
+<h2>Expediente</h2>
+{{range .Expedientes}}<table>
+<tr><th>Antecedentes médicos</th><td>{{.AntecedentesMedicos}}</td></tr>
+<tr><th>Alergias</th><td>{{.Alergias}}</td></tr>
+<tr><th>Tratamientos</th><td>{{.Tratamientos}}</td></tr>
+<tr><th>Última actualización</th><td>{{.FechaActualizacion}}</td></tr>
+</table>
+{{else}}<p>Sin expediente.</p>{{end}}
+
+<h2>Consultas</h2>
+{{if .Consultas}}<table>
+<tr><th>Fecha</th><th>Médico</th><th>Enfermera</th><th>Diagnóstico</th><th>Estado</th></tr>
+{{range .Consultas}}<tr><td>{{.FechaHora}}</td><td>{{.Medico}}</td><td>{{.Enfermera}}</td><td>{{.Diagnostico}}</td><td>{{.Estado}}</td></tr>
+{{end}}</table>{{else}}<p>Sin consultas.</p>{{end}}
+
+<h2>Actividad de la cuenta</h2>
+{{if .Auditoria}}<table>
+<tr><th>Fecha</th><th>Acción</th><th>Resultado</th><th>Detalle</th></tr>
+{{range .Auditoria}}<tr><td>{{.Fecha}}</td><td>{{.Accion}}</td><td>{{.Estado}}</td><td>{{.Detalle}}</td></tr>
+{{end}}</table>{{else}}<p>Sin actividad registrada.</p>{{end}}
+</body>
+</html>
+`))
+
+// buildAccountArchive empaqueta la exportación en un ZIP con datos.json y datos.html.
+func buildAccountArchive(data *accountExport) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	w, err := zw.Create("datos.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+
+	w, err = zw.Create("datos.html")
+	if err != nil {
+		return nil, err
+	}
+	if err := accountExportHTML.Execute(w, data); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExportAccountData descarga un ZIP con todos los datos del usuario: perfil, citas, expediente,
+// consultas y su actividad en el registro de auditoría, en JSON y en un documento HTML.
+func ExportAccountData(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	data, err := collectAccountData(context.Background(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "export_account", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al reunir datos para exportar: %v", err)
+		utils.LogAction(userID, "export_account", "fallido", "Error al reunir datos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al exportar datos"})
+	}
+	archive, err := buildAccountArchive(data)
+	if err != nil {
+		log.Printf("Error al generar archivo de exportación: %v", err)
+		utils.LogAction(userID, "export_account", "fallido", "Error al generar archivo: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al exportar datos"})
+	}
+
+	utils.LogAction(userID, "export_account", "exitoso", "Datos exportados: "+strconv.Itoa(len(data.Citas))+" citas, "+
+		strconv.Itoa(len(data.Expedientes))+" expedientes, "+strconv.Itoa(len(data.Consultas))+" consultas")
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition,
+		`attachment; filename="mis-datos-`+strconv.Itoa(userID)+"-"+data.GeneradoAt.Format("20060102")+`.zip"`)
+	return c.Send(archive)
+}
+
+// CloseAccount cierra la cuenta del propio usuario tras confirmar contraseña y segundo factor.
+// El login queda desactivado y se borran credenciales y citas pendientes, pero el expediente,
+// las consultas y las citas atendidas se conservan hasta retencion_hasta.
+func CloseAccount(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		Password     string `json:"password"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "close_account", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, "close_account", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar la cuenta"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "close_account", "fallido", "Intento rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	var hash, secret, correo string
+	err = config.Conn.QueryRow(ctx,
+		"SELECT contraseña, totp_secret, correo FROM usuarios WHERE id_usuario = $1", userID).Scan(&hash, &secret, &correo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "close_account", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar usuario: %v", err)
+		utils.LogAction(userID, "close_account", "fallido", "Error al consultar usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar la cuenta"})
+	}
+	if checkPassword(hash, input.Password) != nil {
+		return credentialFailed(c, userID, "close_account", "Contraseña incorrecta", "Contraseña incorrecta")
+	}
+	err = verifyCurrentFactor(ctx, userID, secret, input.TOTPCode, input.RecoveryCode)
+	if factorRejected(err) {
+		return credentialFailed(c, userID, "close_account", "Segundo factor inválido", "Segundo factor rechazado: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error al verificar segundo factor: %v", err)
+		utils.LogAction(userID, "close_account", "fallido", "Error al verificar segundo factor: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar la cuenta"})
+	}
+
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "close_account", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar la cuenta"})
+	}
+	defer tx.Rollback(ctx)
+
+	var retencionHasta time.Time
+	err = tx.QueryRow(ctx,
+		`UPDATE usuarios SET activo = false, desactivado_at = now(), cerrado_at = now(),
+			retencion_hasta = (now() + make_interval(years => $2))::date
+		WHERE id_usuario = $1 RETURNING retencion_hasta`,
+		userID, clinicalRetentionYears()).Scan(&retencionHasta)
+	steps := []string{
+		// Las citas aún no aceptadas se cancelan igual que desde DELETE /appointments
+		"DELETE FROM citas WHERE estado = 'pendiente' AND id_paciente IN (SELECT id_paciente FROM pacientes WHERE id_usuario = $1)",
+		"DELETE FROM recovery_codes WHERE id_usuario = $1",
+		"DELETE FROM webauthn_credentials WHERE id_usuario = $1",
+		"UPDATE password_reset_tokens SET used_at = now() WHERE id_usuario = $1 AND used_at IS NULL",
+		"UPDATE email_verification_tokens SET used_at = now() WHERE id_usuario = $1 AND used_at IS NULL",
+	}
+	for _, step := range steps {
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec(ctx, step, userID)
+	}
+	if err == nil {
+		err = revokeUserSessions(ctx, tx, userID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al cerrar cuenta: %v", err)
+		utils.LogAction(userID, "close_account", "fallido", "Error al cerrar cuenta: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar la cuenta"})
+	}
+
+	retencion := retencionHasta.Format("2006-01-02")
+	sendMailAsync(userID, "close_account", mailer.Message{
+		To:      correo,
+		Subject: "Tu cuenta ha sido cerrada",
+		Body: "Cerramos tu cuenta y todas tus sesiones. Ya no podrás iniciar sesión.\n\n" +
+			"Por obligación legal conservaremos tu expediente clínico y tus consultas hasta el " + retencion + ".\n" +
+			"Si no solicitaste el cierre, comunícate con el hospital.",
+	})
+	utils.LogAction(userID, "close_account", "exitoso", "Cuenta cerrada por su titular; datos clínicos retenidos hasta "+retencion)
+	return c.JSON(fiber.Map{
+		"message":         "Cuenta cerrada",
+		"retencion_hasta": retencion,
+	})
+}
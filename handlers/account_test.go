@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCloseAccountErrors(t *testing.T) {
+	useTestTOTP(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("Contraseña-Actual-123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		userErr   error
+		noUser    bool
+		stepErr   error
+		want      int
+		wantFails int
+	}{
+		{name: "usuario inexistente", noUser: true, want: fiber.StatusNotFound},
+		{name: "error al leer el usuario", userErr: errors.New("conexión perdida"), want: fiber.StatusInternalServerError},
+		{name: "código incorrecto", want: fiber.StatusUnauthorized, wantFails: 1},
+		{name: "error al verificar el código", stepErr: errors.New("conexión perdida"), want: fiber.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failures := map[string]int{}
+			db := newFakeDB(t)
+			db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+			db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+				failures[args[0].(string)]++
+				return fakeResult{rows: [][]interface{}{{failures[args[0].(string)]}}}
+			})
+			db.on("SELECT contraseña, totp_secret, correo FROM usuarios", func([]interface{}) fakeResult {
+				if tc.noUser || tc.userErr != nil {
+					return fakeResult{err: tc.userErr}
+				}
+				return fakeResult{rows: [][]interface{}{{string(hash), testTOTPSeed, "ana@hospital.com"}}}
+			})
+			db.on("SET totp_last_step", func([]interface{}) fakeResult { return fakeResult{err: tc.stepErr} })
+
+			code := "000000"
+			if tc.stepErr != nil {
+				code = totpCodeNow(t)
+			}
+			app := fiber.New()
+			app.Post("/account/close", withUser(1, "Paciente"), CloseAccount)
+			req := httptest.NewRequest("POST", "/account/close", bytes.NewBufferString(
+				`{"password":"Contraseña-Actual-123","totp_code":"`+code+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Fatalf("estado %d, se esperaba %d", resp.StatusCode, tc.want)
+			}
+			if failures[failureScopeAccount] != tc.wantFails || failures[failureScopeIP] != tc.wantFails {
+				t.Fatalf("fallos = %v, se esperaba %d por ámbito", failures, tc.wantFails)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/models"
+	"hospitalaria/utils"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 200
+)
+
+// ListUsers busca usuarios por nombre, apellido o correo (q), rol y estado (activo).
+func ListUsers(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var conditions []string
+	var args []interface{}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		args = append(args, "%"+q+"%")
+		n := strconv.Itoa(len(args))
+		conditions = append(conditions, "(nombre ILIKE $"+n+" OR apellido ILIKE $"+n+" OR correo ILIKE $"+n+")")
+	}
+	if rol := c.Query("rol"); rol != "" {
+		if !models.IsValidRole(rol) {
+			utils.LogAction(userID, "list_users", "fallido", "Rol inválido: "+rol)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Rol inválido"})
+		}
+		args = append(args, rol)
+		conditions = append(conditions, "rol = $"+strconv.Itoa(len(args)))
+	}
+	if activo := c.Query("activo"); activo != "" {
+		value, err := strconv.ParseBool(activo)
+		if err != nil {
+			utils.LogAction(userID, "list_users", "fallido", "Filtro activo inválido: "+activo)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "El filtro activo debe ser true o false"})
+		}
+		args = append(args, value)
+		conditions = append(conditions, "activo = $"+strconv.Itoa(len(args)))
+	}
+
+	limit := c.QueryInt("limit", defaultUserListLimit)
+	if limit <= 0 || limit > maxUserListLimit {
+		limit = defaultUserListLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := "SELECT id_usuario, nombre, apellido, correo, rol, correo_verificado, activo, count(*) OVER () FROM usuarios"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += " ORDER BY id_usuario LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := config.Conn.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Printf("Error al listar usuarios: %v", err)
+		utils.LogAction(userID, "list_users", "fallido", "Error al listar usuarios: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar usuarios"})
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	total := 0
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.Id_usuario, &user.Nombre, &user.Apellido, &user.Correo, &user.Rol,
+			&user.CorreoVerificado, &user.Activo, &total); err != nil {
+			log.Printf("Error al leer usuario: %v", err)
+			utils.LogAction(userID, "list_users", "fallido", "Error al leer usuario: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar usuarios"})
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error al listar usuarios: %v", err)
+		utils.LogAction(userID, "list_users", "fallido", "Error al listar usuarios: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar usuarios"})
+	}
+
+	utils.LogAction(userID, "list_users", "exitoso", "Usuarios listados: "+strconv.Itoa(len(users))+" de "+strconv.Itoa(total))
+	return c.JSON(fiber.Map{
+		"users":  users,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// GetUser devuelve un usuario con los datos de su rol y el estado de su cuenta.
+func GetUser(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	targetID, err := c.ParamsInt("id")
+	if err != nil || targetID <= 0 {
+		utils.LogAction(userID, "read_user_admin", "fallido", "ID de usuario inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de usuario inválido"})
+	}
+
+	ctx := context.Background()
+	var user models.User
+	var totpResetRequired bool
+	var desactivadoAt, cerradoAt, retencionHasta *time.Time
+	err = config.Conn.QueryRow(ctx,
+		`SELECT id_usuario, nombre, apellido, correo, rol, correo_verificado, activo, desactivado_at, totp_reset_required,
+			cerrado_at, retencion_hasta
+		FROM usuarios WHERE id_usuario = $1`, targetID).Scan(
+		&user.Id_usuario, &user.Nombre, &user.Apellido, &user.Correo, &user.Rol, &user.CorreoVerificado,
+		&user.Activo, &desactivadoAt, &totpResetRequired, &cerradoAt, &retencionHasta)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "read_user_admin", "fallido", "Usuario no encontrado: ID "+strconv.Itoa(targetID))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err == nil {
+		err = loadRoleData(ctx, &user)
+	}
+	var lockedUntil *time.Time
+	if err == nil {
+		err = config.Conn.QueryRow(ctx,
+			"SELECT locked_until FROM login_failures WHERE scope = $1 AND subject = $2 AND locked_until > now()",
+			failureScopeAccount, strconv.Itoa(targetID)).Scan(&lockedUntil)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, "read_user_admin", "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener usuario"})
+	}
+
+	utils.LogAction(userID, "read_user_admin", "exitoso", "Usuario consultado: ID "+strconv.Itoa(targetID))
+	return c.JSON(struct {
+		models.User
+		DesactivadoAt     *time.Time `json:"desactivado_at"`
+		CerradoAt         *time.Time `json:"cerrado_at"`
+		RetencionHasta    *time.Time `json:"retencion_hasta"`
+		LockedUntil       *time.Time `json:"locked_until"`
+		TOTPResetRequired bool       `json:"totp_reset_required"`
+	}{user, desactivadoAt, cerradoAt, retencionHasta, lockedUntil, totpResetRequired})
+}
+
+// ChangeUserRole asigna un rol nuevo a un usuario y crea su fila de datos de rol si no la tenía.
+// Las filas del rol anterior se conservan porque pueden estar referenciadas por citas o expedientes.
+// Sus sesiones se revocan porque los tokens llevan el rol.
+func ChangeUserRole(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	targetID, err := c.ParamsInt("id")
+	if err != nil || targetID <= 0 {
+		utils.LogAction(userID, "change_user_role", "fallido", "ID de usuario inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de usuario inválido"})
+	}
+	if targetID == userID {
+		utils.LogAction(userID, "change_user_role", "fallido", "Intento de cambiar el propio rol")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No puedes cambiar tu propio rol"})
+	}
+
+	var input models.User
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "change_user_role", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	if !models.IsValidRole(input.Rol) {
+		utils.LogAction(userID, "change_user_role", "fallido", "Rol inválido: "+input.Rol)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Rol inválido"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "change_user_role", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar rol"})
+	}
+	defer tx.Rollback(ctx)
+
+	var previous string
+	err = tx.QueryRow(ctx, "SELECT rol FROM usuarios WHERE id_usuario = $1 FOR UPDATE", targetID).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "change_user_role", "fallido", "Usuario no encontrado: ID "+strconv.Itoa(targetID))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, "change_user_role", "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar rol"})
+	}
+	if previous == input.Rol {
+		utils.LogAction(userID, "change_user_role", "fallido", "El usuario ID "+strconv.Itoa(targetID)+" ya tiene el rol "+input.Rol)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El usuario ya tiene ese rol"})
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE usuarios SET rol = $2 WHERE id_usuario = $1", targetID, input.Rol)
+	var exists bool
+	if err == nil {
+		exists, err = hasRoleData(ctx, tx, targetID, input.Rol)
+	}
+	if err == nil && !exists {
+		err = insertRoleData(ctx, tx, targetID, input.Rol, input)
+	}
+	if err == nil {
+		err = revokeUserSessions(ctx, tx, targetID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al cambiar rol: %v", err)
+		utils.LogAction(userID, "change_user_role", "fallido", "Error al cambiar rol: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar rol"})
+	}
+
+	utils.LogAction(userID, "change_user_role", "exitoso",
+		"Rol del usuario ID "+strconv.Itoa(targetID)+" cambiado de "+previous+" a "+input.Rol)
+	return c.JSON(fiber.Map{"message": "Rol actualizado", "rol": input.Rol})
+}
+
+// setUserActive activa o desactiva una cuenta. Al desactivarla se revocan todas sus sesiones.
+func setUserActive(c *fiber.Ctx, action string, active bool) error {
+	userID := c.Locals("user_id").(int)
+	targetID, err := c.ParamsInt("id")
+	if err != nil || targetID <= 0 {
+		utils.LogAction(userID, action, "fallido", "ID de usuario inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de usuario inválido"})
+	}
+	if targetID == userID {
+		utils.LogAction(userID, action, "fallido", "Intento de cambiar el estado de la propia cuenta")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No puedes cambiar el estado de tu propia cuenta"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar cuenta"})
+	}
+	defer tx.Rollback(ctx)
+
+	var current bool
+	err = tx.QueryRow(ctx, "SELECT activo FROM usuarios WHERE id_usuario = $1 FOR UPDATE", targetID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, action, "fallido", "Usuario no encontrado: ID "+strconv.Itoa(targetID))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar cuenta"})
+	}
+	if current == active {
+		utils.LogAction(userID, action, "fallido", "La cuenta ID "+strconv.Itoa(targetID)+" ya estaba en ese estado")
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "La cuenta ya está en ese estado"})
+	}
+
+	if active {
+		// Reabrir una cuenta cerrada por su titular también anula el cierre
+		_, err = tx.Exec(ctx,
+			"UPDATE usuarios SET activo = true, desactivado_at = NULL, cerrado_at = NULL, retencion_hasta = NULL WHERE id_usuario = $1", targetID)
+	} else {
+		_, err = tx.Exec(ctx, "UPDATE usuarios SET activo = false, desactivado_at = now() WHERE id_usuario = $1", targetID)
+		if err == nil {
+			err = revokeUserSessions(ctx, tx, targetID)
+		}
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al actualizar cuenta: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al actualizar cuenta: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar cuenta"})
+	}
+
+	if active {
+		utils.LogAction(userID, action, "exitoso", "Cuenta reactivada: ID "+strconv.Itoa(targetID))
+		return c.JSON(fiber.Map{"message": "Cuenta reactivada"})
+	}
+	utils.LogAction(userID, action, "exitoso", "Cuenta desactivada y sesiones revocadas: ID "+strconv.Itoa(targetID))
+	return c.JSON(fiber.Map{"message": "Cuenta desactivada"})
+}
+
+// DeactivateUser impide el inicio de sesión de una cuenta y cierra sus sesiones.
+func DeactivateUser(c *fiber.Ctx) error {
+	return setUserActive(c, "deactivate_user", false)
+}
+
+// ReactivateUser vuelve a permitir el inicio de sesión de una cuenta desactivada.
+func ReactivateUser(c *fiber.Ctx) error {
+	return setUserActive(c, "reactivate_user", true)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/apikeys"
+	"hospitalaria/config"
+	"hospitalaria/policy"
+	"hospitalaria/utils"
+)
+
+// APIKey es una clave de API tal como se muestra en el listado; el secreto nunca se devuelve.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Prefix     string     `json:"prefix"`
+	Nombre     string     `json:"nombre"`
+	Scopes     []string   `json:"scopes"`
+	CreadoPor  *int       `json:"creado_por"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func apiKeyTTLDays() (def, maxDays int) {
+	return utils.GetEnvInt("API_KEY_TTL_DAYS", 90), utils.GetEnvInt("API_KEY_MAX_TTL_DAYS", 365)
+}
+
+// CreateAPIKey emite una clave de API con los scopes indicados. La clave completa solo aparece en esta respuesta.
+func CreateAPIKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		Nombre        string   `json:"nombre"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "create_api_key", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	if input.Nombre == "" || len(input.Scopes) == 0 {
+		utils.LogAction(userID, "create_api_key", "fallido", "Nombre o scopes vacíos")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "nombre y scopes son obligatorios"})
+	}
+	for _, scope := range input.Scopes {
+		if !policy.APIKeyScope(policy.Permission(scope)) {
+			utils.LogAction(userID, "create_api_key", "fallido", "Scope no permitido para claves: "+scope)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Scope no permitido para claves de API: " + scope})
+		}
+	}
+	def, maxDays := apiKeyTTLDays()
+	days := input.ExpiresInDays
+	if days == 0 {
+		days = def
+	}
+	if days < 0 || days > maxDays {
+		utils.LogAction(userID, "create_api_key", "fallido", "Vigencia inválida: "+strconv.Itoa(days)+" días")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "expires_in_days debe estar entre 1 y " + strconv.Itoa(maxDays)})
+	}
+
+	key, prefix, hash, err := apikeys.Generate()
+	if err != nil {
+		log.Printf("Error al generar clave de API: %v", err)
+		utils.LogAction(userID, "create_api_key", "fallido", "Error al generar clave: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear clave de API"})
+	}
+	expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	var id int
+	err = config.Conn.QueryRow(context.Background(),
+		"INSERT INTO api_keys (prefix, key_hash, nombre, scopes, creado_por, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_clave",
+		prefix, hash, input.Nombre, input.Scopes, userID, expiresAt).Scan(&id)
+	if err != nil {
+		log.Printf("Error al guardar clave de API: %v", err)
+		utils.LogAction(userID, "create_api_key", "fallido", "Error al guardar clave: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear clave de API"})
+	}
+
+	utils.LogAction(userID, "create_api_key", "exitoso", "Clave "+prefix+" ("+input.Nombre+") creada con scopes "+strings.Join(input.Scopes, ","))
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id":         id,
+		"prefix":     prefix,
+		"api_key":    key,
+		"scopes":     input.Scopes,
+		"expires_at": expiresAt,
+		"message":    "Guarda la clave ahora: no volverá a mostrarse",
+	})
+}
+
+// ListAPIKeys lista todas las claves, incluidas las revocadas y expiradas.
+func ListAPIKeys(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	rows, err := config.Conn.Query(context.Background(),
+		`SELECT id_clave, prefix, nombre, scopes, creado_por, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		log.Printf("Error al listar claves de API: %v", err)
+		utils.LogAction(userID, "list_api_keys", "fallido", "Error al listar claves: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar claves de API"})
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Prefix, &k.Nombre, &k.Scopes, &k.CreadoPor, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			log.Printf("Error al leer clave de API: %v", err)
+			utils.LogAction(userID, "list_api_keys", "fallido", "Error al leer clave: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar claves de API"})
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error al listar claves de API: %v", err)
+		utils.LogAction(userID, "list_api_keys", "fallido", "Error al listar claves: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar claves de API"})
+	}
+
+	utils.LogAction(userID, "list_api_keys", "exitoso", "Claves listadas: "+strconv.Itoa(len(keys)))
+	return c.JSON(keys)
+}
+
+// RevokeAPIKey revoca una clave; deja de aceptarse en la siguiente petición.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		utils.LogAction(userID, "revoke_api_key", "fallido", "ID de clave inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de clave inválido"})
+	}
+	var prefix string
+	err = config.Conn.QueryRow(context.Background(),
+		"UPDATE api_keys SET revoked_at = now() WHERE id_clave = $1 AND revoked_at IS NULL RETURNING prefix", id).Scan(&prefix)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "revoke_api_key", "fallido", "Clave no encontrada o ya revocada: ID "+strconv.Itoa(id))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Clave no encontrada o ya revocada"})
+	}
+	if err != nil {
+		log.Printf("Error al revocar clave de API: %v", err)
+		utils.LogAction(userID, "revoke_api_key", "fallido", "Error al revocar clave: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al revocar clave de API"})
+	}
+	utils.LogAction(userID, "revoke_api_key", "exitoso", "Clave "+prefix+" revocada")
+	return c.JSON(fiber.Map{"message": "Clave revocada"})
+}
@@ -2,52 +2,57 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/pquerna/otp/totp"
-	"github.com/skip2/go-qrcode"
-	"golang.org/x/crypto/bcrypt"
 	"hospitalaria/config"
 	"hospitalaria/models"
+	"hospitalaria/passwordpolicy"
 	"hospitalaria/utils"
 )
 
-func CheckPasswordStrength(password string) (bool, string) {
-	if len(password) < 12 {
-		return false, "La contraseña debe tener al menos 12 caracteres"
-	}
-	if !strings.ContainsAny(password, "!@#$%^&*()") {
-		return false, "La contraseña debe incluir símbolos"
-	}
-	if !strings.ContainsAny(password, "0123456789") {
-		return false, "La contraseña debe incluir números"
-	}
-	return true, "Contraseña segura"
+// CheckPasswordStrength devuelve todas las infracciones de la política de contraseñas; nil si es válida.
+func CheckPasswordStrength(password string, user passwordpolicy.UserInfo) []string {
+	return config.PasswordPolicy.Validate(password, user)
 }
 
-func GenerateTokens(userID int, role string) (string, string, error) {
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"role":    role,
-		"exp":     time.Now().Add(10 * time.Minute).Unix(),
-	})
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"role":    role, // Añadido para consistencia
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+// respondWeakPassword responde 400 con la lista completa de infracciones.
+func respondWeakPassword(c *fiber.Ctx, userID int, action string, violations []string) error {
+	utils.LogAction(userID, action, "fallido", "Contraseña débil: "+strings.Join(violations, "; "))
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error":      "La contraseña no cumple la política de seguridad",
+		"violations": violations,
 	})
-	access, err := accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// isUniqueViolation indica si err es una violación de restricción UNIQUE de PostgreSQL.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" // unique_violation
+}
+
+// GenerateTokens emite un access token y un refresh token que abre una nueva familia de sesión.
+// El refresh token se persiste hasheado para permitir rotación y detección de reutilización.
+func GenerateTokens(userID int, role string, device DeviceInfo) (string, string, error) {
+	familyID := uuid.NewString()
+	access, accessJTI, accessExpiresAt, err := signAccessToken(userID, role, familyID)
 	if err != nil {
 		return "", "", err
 	}
-	refresh, err := refreshToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	refresh, jti, expiresAt, err := issueRefreshToken(userID, role, familyID)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = config.Conn.Exec(context.Background(), insertRefreshTokenSQL,
+		jti, userID, familyID, hashToken(refresh), device.UserAgent, device.IP, expiresAt, accessJTI, accessExpiresAt)
 	if err != nil {
 		return "", "", err
 	}
@@ -64,105 +69,137 @@ func CreateUser(c *fiber.Ctx) error {
 		FechaNacimiento string `json:"fecha_nacimiento,omitempty"`
 		Genero          string `json:"genero,omitempty"`
 		Direccion       string `json:"direccion,omitempty"`
-		Especialidad    string `json:"especialidad,omitempty"`
-		NumeroColegiado string `json:"numero_colegiado,omitempty"`
-		Certificacion   string `json:"certificacion,omitempty"`
 	}
 	var input UserInput
 	if err := c.BodyParser(&input); err != nil {
 		utils.LogAction(0, "create_user", "fallido", "JSON inválido: "+err.Error())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
 	}
+	input.Correo = normalizeEmail(input.Correo)
+	if !validEmail(input.Correo) {
+		utils.LogAction(0, "create_user", "fallido", "Correo inválido: "+input.Correo)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Correo inválido"})
+	}
+	if input.Rol == "" {
+		input.Rol = "Paciente"
+	}
+	if !models.IsValidRole(input.Rol) {
+		utils.LogAction(0, "create_user", "fallido", "Rol inválido: "+input.Rol)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Rol inválido"})
+	}
+	// El personal clínico se da de alta solo mediante invitación (AcceptInvitation)
+	if input.Rol != "Paciente" {
+		utils.LogAction(0, "create_user", "fallido", "Registro público con rol no permitido: "+input.Rol)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "El registro público solo admite pacientes; el personal se registra por invitación"})
+	}
 
-	isStrong, message := CheckPasswordStrength(input.Password)
-	if !isStrong {
-		utils.LogAction(0, "create_user", "fallido", "Contraseña débil: "+message)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": message})
+	violations := CheckPasswordStrength(input.Password, passwordpolicy.UserInfo{
+		Nombre: input.Nombre, Apellido: input.Apellido, Correo: input.Correo,
+	})
+	if len(violations) > 0 {
+		return respondWeakPassword(c, 0, "create_user", violations)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hash, err := hashPassword(input.Password)
 	if err != nil {
 		log.Printf("Error al hashear contraseña: %v", err)
 		utils.LogAction(0, "create_user", "fallido", "Error al hashear contraseña: "+err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al procesar contraseña"})
 	}
 
-	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      "MyHospitalApp",
-		AccountName: input.Correo,
-	})
+	key, err := totp.Generate(currentTOTPConfig().generateOpts(input.Correo))
 	if err != nil {
 		log.Printf("Error al generar secreto TOTP: %v", err)
 		utils.LogAction(0, "create_user", "fallido", "Error al generar TOTP: "+err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código TOTP"})
 	}
+	totpQR, err := totpQRDataURI(key)
+	if err != nil {
+		log.Printf("Error al generar código QR: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al generar QR: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código QR"})
+	}
+	user := models.User{
+		Nombre:          input.Nombre,
+		Apellido:        input.Apellido,
+		Correo:          input.Correo,
+		Contraseña:      hash,
+		Rol:             input.Rol,
+		FechaNacimiento: input.FechaNacimiento,
+		Genero:          input.Genero,
+		Direccion:       input.Direccion,
+	}
 
+	// Usuario, datos de rol, códigos de recuperación y token de verificación se guardan juntos:
+	// si algo falla no queda una cuenta sin fila de rol
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar usuario"})
+	}
+	defer tx.Rollback(ctx)
+
+	// El secreto TOTP se cifra ligado al id del usuario, así que se guarda tras el INSERT
 	var userID int
-	user := models.User{
-		Nombre:      input.Nombre,
-		Apellido:    input.Apellido,
-		Correo:      input.Correo,
-		Contraseña:  string(hash),
-		Rol:         input.Rol,
-		Totp_secret: key.Secret(),
-	}
-	err = config.Conn.QueryRow(context.Background(),
-		"INSERT INTO usuarios (nombre, apellido, correo, contraseña, rol, totp_secret) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id_usuario",
-		user.Nombre, user.Apellido, user.Correo, user.Contraseña, user.Rol, user.Totp_secret).Scan(&userID)
+	err = tx.QueryRow(ctx,
+		"INSERT INTO usuarios (nombre, apellido, correo, contraseña, rol, totp_secret) VALUES ($1, $2, $3, $4, $5, '') RETURNING id_usuario",
+		user.Nombre, user.Apellido, user.Correo, user.Contraseña, user.Rol).Scan(&userID)
+	if isUniqueViolation(err) {
+		utils.LogAction(0, "create_user", "fallido", "Correo ya registrado: "+user.Correo)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El correo ya está registrado"})
+	}
 	if err != nil {
 		log.Printf("Error al insertar usuario: %v", err)
 		utils.LogAction(0, "create_user", "fallido", "Error al insertar usuario: "+err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar usuario"})
 	}
-	utils.LogAction(userID, "create_user", "exitoso", "Usuario creado con ID "+strconv.Itoa(userID))
-
-	if user.Rol == "Paciente" {
-		_, err = config.Conn.Exec(context.Background(),
-			"INSERT INTO pacientes (id_usuario, fecha_nacimiento, genero, direccion) VALUES ($1, $2, $3, $4)",
-			userID, input.FechaNacimiento, input.Genero, input.Direccion)
-		if err != nil {
-			log.Printf("Error al insertar paciente: %v", err)
-			utils.LogAction(userID, "create_paciente", "fallido", "Error al insertar datos de paciente: "+err.Error())
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar datos de rol: " + err.Error()})
-		}
-		utils.LogAction(userID, "create_paciente", "exitoso", "Datos de paciente creados para usuario "+strconv.Itoa(userID))
-	} else if user.Rol == "Medico" {
-		_, err = config.Conn.Exec(context.Background(),
-			"INSERT INTO medicos (id_usuario, especialidad, numero_colegiado) VALUES ($1, $2, $3)",
-			userID, input.Especialidad, input.NumeroColegiado)
-		if err != nil {
-			log.Printf("Error al insertar medico: %v", err)
-			utils.LogAction(userID, "create_medico", "fallido", "Error al insertar datos de medico: "+err.Error())
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar datos de rol: " + err.Error()})
-		}
-		utils.LogAction(userID, "create_medico", "exitoso", "Datos de medico creados para usuario "+strconv.Itoa(userID))
-	} else if user.Rol == "Enfermero" {
-		_, err = config.Conn.Exec(context.Background(),
-			"INSERT INTO enfermeras (id_usuario, certificacion) VALUES ($1, $2)",
-			userID, input.Certificacion)
-		if err != nil {
-			log.Printf("Error al insertar enfermero: %v", err)
-			utils.LogAction(userID, "create_enfermero", "fallido", "Error al insertar datos de enfermero: "+err.Error())
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar datos de rol: " + err.Error()})
-		}
-		utils.LogAction(userID, "create_enfermero", "exitoso", "Datos de enfermero creados para usuario "+strconv.Itoa(userID))
+	sealedSecret, err := sealTOTPSecret(userID, key.Secret())
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE usuarios SET totp_secret = $2 WHERE id_usuario = $1", userID, sealedSecret)
 	}
-
-	qrCode, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
 	if err != nil {
-		log.Printf("Error al generar código QR: %v", err)
-		utils.LogAction(userID, "create_user", "fallido", "Error al generar QR: "+err.Error())
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código QR"})
+		log.Printf("Error al guardar secreto TOTP: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al guardar secreto TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código TOTP"})
+	}
+	if err := insertRoleData(ctx, tx, userID, user.Rol, user); err != nil {
+		log.Printf("Error al insertar datos de rol: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al insertar datos de "+user.Rol+": "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar datos de rol"})
+	}
+	recoveryCodes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		log.Printf("Error al generar códigos de recuperación: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al generar códigos de recuperación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+	verificationToken, err := issueEmailVerification(ctx, tx, userID, user.Correo)
+	if err != nil {
+		log.Printf("Error al generar token de verificación: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al generar verificación de correo: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar verificación de correo"})
 	}
-	qrBase64 := base64.StdEncoding.EncodeToString(qrCode)
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Error al confirmar registro: %v", err)
+		utils.LogAction(0, "create_user", "fallido", "Error al confirmar registro: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al guardar usuario"})
+	}
+	utils.LogAction(userID, "create_user", "exitoso", "Usuario "+user.Rol+" creado con ID "+strconv.Itoa(userID))
+
+	sendVerificationEmail(userID, user.Correo, verificationToken)
 
 	return c.JSON(fiber.Map{
-		"id_usuario":  userID,
-		"nombre":      user.Nombre,
-		"correo":      user.Correo,
-		"rol":         user.Rol,
-		"totp_secret": user.Totp_secret,
-		"totp_qr":     "data:image/png;base64," + qrBase64,
+		"id_usuario": userID,
+		"nombre":     user.Nombre,
+		"correo":     user.Correo,
+		"rol":        user.Rol,
+		// El secreto solo viaja dentro del QR; en la base de datos queda cifrado
+		"totp_qr": totpQR,
+		// Se muestran una única vez; solo se guarda su hash
+		"recovery_codes":    recoveryCodes,
+		"correo_verificado": false,
 	})
 }
 
@@ -171,34 +208,131 @@ func Login(c *fiber.Ctx) error {
 		Correo   string `json:"correo"`
 		Password string `json:"password"`
 		TOTPCode string `json:"totp_code"`
+		// Alternativa a totp_code si el usuario perdió su dispositivo
+		RecoveryCode string `json:"recovery_code"`
+		// "totp" (por defecto) o "webauthn"
+		SecondFactor string `json:"second_factor"`
 	}
 	if err := c.BodyParser(&input); err != nil {
 		utils.LogAction(0, "login", "fallido", "JSON inválido: "+err.Error())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
 	}
+	input.Correo = normalizeEmail(input.Correo)
+
+	ctx := context.Background()
+	policy := currentLockoutPolicy()
+	block, err := checkLoginThrottle(ctx, failureScopeIP, c.IP(), policy)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(0, "login", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if block != nil {
+		utils.LogAction(0, "login", "fallido", "IP bloqueada por intentos fallidos: "+c.IP())
+		return respondLoginBlocked(c, block)
+	}
 
 	var user models.User
-	err := config.Conn.QueryRow(context.Background(),
-		"SELECT id_usuario, contraseña, rol, totp_secret FROM usuarios WHERE correo = $1", input.Correo).Scan(
-		&user.Id_usuario, &user.Contraseña, &user.Rol, &user.Totp_secret)
+	var totpResetRequired bool
+	err = config.Conn.QueryRow(ctx,
+		"SELECT id_usuario, contraseña, rol, totp_secret, totp_reset_required, correo_verificado, activo FROM usuarios WHERE lower(correo) = $1", input.Correo).Scan(
+		&user.Id_usuario, &user.Contraseña, &user.Rol, &user.Totp_secret, &totpResetRequired, &user.CorreoVerificado, &user.Activo)
 	if err != nil {
-		utils.LogAction(0, "login", "fallido", "Correo no encontrado o error en consulta: "+err.Error())
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Credenciales inválidas"})
+		return loginFailed(c, 0, "Credenciales inválidas", "Correo no encontrado o error en consulta: "+err.Error())
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Contraseña), []byte(input.Password)); err != nil {
-		utils.LogAction(user.Id_usuario, "login", "fallido", "Contraseña incorrecta para "+input.Correo)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Credenciales inválidas"})
+	block, err = checkLoginThrottle(ctx, failureScopeAccount, strconv.Itoa(user.Id_usuario), policy)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(user.Id_usuario, "login", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if block != nil {
+		utils.LogAction(user.Id_usuario, "login", "fallido", "Intento rechazado por bloqueo o espera para "+input.Correo)
+		return respondLoginBlocked(c, block)
+	}
+
+	if err := checkPassword(user.Contraseña, input.Password); err != nil {
+		return loginFailed(c, user.Id_usuario, "Credenciales inválidas", "Contraseña incorrecta para "+input.Correo)
 	}
 	utils.LogAction(user.Id_usuario, "login", "exitoso", "Contraseña validada para "+input.Correo)
+	rehashPasswordIfNeeded(ctx, user.Id_usuario, user.Contraseña, input.Password)
 
-	if !totp.Validate(input.TOTPCode, user.Totp_secret) {
-		utils.LogAction(user.Id_usuario, "login", "fallido", "Código TOTP inválido para "+input.Correo)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Código TOTP inválido"})
+	if !user.Activo {
+		utils.LogAction(user.Id_usuario, "login", "fallido", "Cuenta desactivada: "+input.Correo)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cuenta desactivada"})
+	}
+	if config.OIDCStaffRequired && user.Rol != "Paciente" {
+		utils.LogAction(user.Id_usuario, "login", "fallido", "Personal debe usar el IdP corporativo: "+input.Correo)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":         "El personal inicia sesión con el proveedor de identidad corporativo",
+			"oidc_required": true,
+		})
+	}
+	if !user.CorreoVerificado {
+		utils.LogAction(user.Id_usuario, "login", "fallido", "Correo sin verificar: "+input.Correo)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error":              "Debes verificar tu correo antes de iniciar sesión",
+			"email_not_verified": true,
+		})
 	}
-	utils.LogAction(user.Id_usuario, "login", "exitoso", "Código TOTP validado para "+input.Correo)
 
-	accessToken, refreshToken, err := GenerateTokens(user.Id_usuario, user.Rol)
+	// Un administrador reinició el TOTP: la sesión solo se emite tras inscribir un secreto nuevo
+	if totpResetRequired {
+		qr, err := startTOTPEnrollment(ctx, user.Id_usuario)
+		if err != nil {
+			log.Printf("Error al iniciar inscripción TOTP: %v", err)
+			utils.LogAction(user.Id_usuario, "login", "fallido", "Error al iniciar inscripción TOTP: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+		}
+		enrollmentToken, err := signEnrollmentToken(user.Id_usuario)
+		if err != nil {
+			log.Printf("Error al firmar token de inscripción: %v", err)
+			utils.LogAction(user.Id_usuario, "login", "fallido", "Error al firmar token de inscripción: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+		}
+		utils.LogAction(user.Id_usuario, "login", "exitoso", "Inscripción TOTP obligatoria iniciada para "+input.Correo)
+		return c.JSON(fiber.Map{
+			"totp_enrollment_required": true,
+			"enrollment_token":         enrollmentToken,
+			"totp_qr":                  qr,
+		})
+	}
+
+	switch {
+	case input.RecoveryCode != "":
+		err = consumeRecoveryCode(ctx, user.Id_usuario, input.RecoveryCode)
+		if errors.Is(err, errRecoveryCodeInvalid) {
+			return loginFailed(c, user.Id_usuario, "Código de recuperación inválido", "Código de recuperación inválido para "+input.Correo)
+		}
+		if err != nil {
+			log.Printf("Error al verificar código de recuperación: %v", err)
+			utils.LogAction(user.Id_usuario, "login", "fallido", "Error al verificar código de recuperación: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+		}
+		utils.LogAction(user.Id_usuario, "login", "exitoso", "Código de recuperación usado para "+input.Correo)
+	case input.SecondFactor == "webauthn":
+		// La sesión se emite en /login/webauthn tras validar la aserción
+		return beginWebAuthnLogin(c, user.Id_usuario, input.Correo)
+	default:
+		err = verifyTOTP(ctx, user.Id_usuario, input.TOTPCode, user.Totp_secret)
+		if errors.Is(err, errTOTPReused) {
+			return loginFailed(c, user.Id_usuario, "Código TOTP inválido", "Código TOTP reutilizado para "+input.Correo)
+		}
+		if errors.Is(err, errTOTPInvalid) {
+			return loginFailed(c, user.Id_usuario, "Código TOTP inválido", "Código TOTP inválido para "+input.Correo)
+		}
+		if err != nil {
+			log.Printf("Error al verificar TOTP: %v", err)
+			utils.LogAction(user.Id_usuario, "login", "fallido", "Error al verificar TOTP: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+		}
+		utils.LogAction(user.Id_usuario, "login", "exitoso", "Código TOTP validado para "+input.Correo)
+	}
+
+	loginSucceeded(ctx, c, user.Id_usuario)
+
+	accessToken, refreshToken, err := GenerateTokens(user.Id_usuario, user.Rol, deviceFromCtx(c))
 	if err != nil {
 		log.Printf("Error al generar tokens: %v", err)
 		utils.LogAction(user.Id_usuario, "login", "fallido", "Error al generar tokens: "+err.Error())
@@ -213,21 +347,6 @@ func Login(c *fiber.Ctx) error {
 	})
 }
 
-func GetUserProfile(c *fiber.Ctx) error {
-	userID := c.Locals("user_id").(int)
-	var user models.User
-	err := config.Conn.QueryRow(context.Background(),
-		"SELECT id_usuario, nombre, apellido, correo, rol FROM usuarios WHERE id_usuario = $1", userID).Scan(
-		&user.Id_usuario, &user.Nombre, &user.Apellido, &user.Correo, &user.Rol)
-	if err != nil {
-		log.Printf("Error al obtener perfil: %v", err)
-		utils.LogAction(userID, "read_user", "fallido", "Error al obtener perfil: "+err.Error())
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
-	}
-	utils.LogAction(userID, "read_user", "exitoso", "Perfil leído para usuario "+strconv.Itoa(userID))
-	return c.JSON(user)
-}
-
 func RefreshToken(c *fiber.Ctx) error {
 	type RefreshTokenInput struct {
 		RefreshToken string `json:"refresh_token"`
@@ -239,9 +358,7 @@ func RefreshToken(c *fiber.Ctx) error {
 	}
 
 	// Parsear y validar el refresh token
-	token, err := jwt.Parse(input.RefreshToken, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	token, err := jwt.Parse(input.RefreshToken, config.JWTKeys.Keyfunc)
 
 	if err != nil || !token.Valid {
 		log.Printf("Refresh token inválido: %v", err)
@@ -253,58 +370,105 @@ func RefreshToken(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Claim inválido"})
 	}
 
-	// Extraer user_id y verificar expiración
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user_id no encontrado en token"})
+	if tokenType, _ := claims["token_type"].(string); tokenType != "refresh" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de refresco inválido"})
 	}
-	userID := int(userIDFloat)
-
-	expiry, ok := claims["exp"].(float64)
-	if !ok || time.Unix(int64(expiry), 0).Before(time.Now()) {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de refresco expirado"})
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "jti no encontrado en token"})
 	}
 
-	// Obtener el rol desde el token (ya que no se guarda en refresh_token originalmente, lo simulamos)
-	role, ok := claims["role"].(string)
-	if !ok {
-		// Si no está en el refresh_token, consultarlo desde la base de datos
-		var userRole string
-		err := config.Conn.QueryRow(context.Background(), "SELECT rol FROM usuarios WHERE id_usuario = $1", userID).Scan(&userRole)
-		if err != nil {
-			log.Printf("Error al obtener rol para userID %d: %v", userID, err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener rol"})
-		}
-		role = userRole
+	// Rotación de un solo uso: el token presentado queda consumido y se emite uno nuevo
+	userID, accessToken, refreshToken, err := rotateRefreshToken(context.Background(), jti, input.RefreshToken, deviceFromCtx(c))
+	if errors.Is(err, errRefreshTokenReused) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de refresco reutilizado, sesión revocada"})
+	}
+	if errors.Is(err, errRefreshTokenInvalid) {
+		utils.LogAction(userID, "refresh_token", "fallido", "Token de refresco inválido, revocado o expirado")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de refresco inválido"})
+	}
+	if err != nil {
+		log.Printf("Error al rotar refresh token: %v", err)
+		utils.LogAction(userID, "refresh_token", "fallido", "Error al rotar token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar token"})
 	}
 
-	// Generar nuevo access token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"role":    role,
-		"exp":     time.Now().Add(10 * time.Minute).Unix(),
+	utils.LogAction(userID, "refresh_token", "exitoso", "Token renovado")
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
 	})
+}
 
-	accessTokenStr, err := accessToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+// Logout cierra la sesión actual: revoca su familia de refresh tokens y el access token presentado.
+func Logout(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	jti := c.Locals("jti").(string)
+	familyID := c.Locals("family_id").(string)
+	expiresAt := c.Locals("token_exp").(time.Time)
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
 	if err != nil {
-		log.Printf("Error al firmar nuevo access token: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar token"})
+		log.Printf("Error al iniciar transacción de logout: %v", err)
+		utils.LogAction(userID, "logout", "fallido", "Error al cerrar sesión: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
 	}
+	defer tx.Rollback(ctx)
 
-	// Opcional: Generar nuevo refresh token para rotación
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
-	refreshTokenStr, err := refreshToken.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err := revokeAccessToken(ctx, tx, jti, userID, expiresAt); err != nil {
+		utils.LogAction(userID, "logout", "fallido", "Error al revocar token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+	if familyID != "" {
+		if err := revokeFamily(ctx, tx, familyID); err != nil {
+			utils.LogAction(userID, "logout", "fallido", "Error al revocar sesión: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.LogAction(userID, "logout", "fallido", "Error al confirmar logout: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+
+	utils.LogAction(userID, "logout", "exitoso", "Sesión cerrada: "+familyID)
+	return c.JSON(fiber.Map{"message": "Sesión cerrada"})
+}
+
+// LogoutAll cierra todas las sesiones del usuario, incluida la actual.
+func LogoutAll(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	jti := c.Locals("jti").(string)
+	expiresAt := c.Locals("token_exp").(time.Time)
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
 	if err != nil {
-		log.Printf("Error al firmar nuevo refresh token: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar token de refresco"})
+		log.Printf("Error al iniciar transacción de logout: %v", err)
+		utils.LogAction(userID, "logout_all", "fallido", "Error al cerrar sesiones: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesiones"})
 	}
+	defer tx.Rollback(ctx)
 
-	utils.LogAction(userID, "refresh_token", "exitoso", "Token renovado")
-	return c.JSON(fiber.Map{
-		"access_token":  accessTokenStr,
-		"refresh_token": refreshTokenStr, // Opcional, para rotación
-	})
+	if err := revokeAccessToken(ctx, tx, jti, userID, expiresAt); err != nil {
+		utils.LogAction(userID, "logout_all", "fallido", "Error al revocar token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesiones"})
+	}
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		utils.LogAction(userID, "logout_all", "fallido", "Error al revocar sesiones: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesiones"})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.LogAction(userID, "logout_all", "fallido", "Error al confirmar logout: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesiones"})
+	}
+
+	utils.LogAction(userID, "logout_all", "exitoso", "Todas las sesiones cerradas")
+	return c.JSON(fiber.Map{"message": "Todas las sesiones cerradas"})
+}
+
+// GetJWKS publica las claves públicas de verificación para que otros servicios validen nuestros tokens.
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": config.JWTKeys.JWKS()})
 }
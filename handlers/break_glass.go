@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/access"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/utils"
+)
+
+// breakGlassMinReason es la longitud mínima del motivo: debe poder revisarse después sin preguntar al médico.
+const breakGlassMinReason = 15
+
+func breakGlassTTL() time.Duration {
+	return time.Duration(utils.GetEnvInt("BREAK_GLASS_MINUTES", 60)) * time.Minute
+}
+
+// complianceRecipients son los correos del equipo de cumplimiento (COMPLIANCE_EMAILS, separados por comas).
+func complianceRecipients() []string {
+	var to []string
+	for _, addr := range strings.Split(os.Getenv("COMPLIANCE_EMAILS"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// BreakGlassAccess es un acceso de emergencia tal como lo ve el equipo de cumplimiento.
+type BreakGlassAccess struct {
+	ID         int       `json:"id"`
+	IDUsuario  int       `json:"id_usuario"`
+	Medico     string    `json:"medico"`
+	IDPaciente int       `json:"id_paciente"`
+	Paciente   string    `json:"paciente"`
+	Motivo     string    `json:"motivo"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Vigente    bool      `json:"vigente"`
+}
+
+// BreakGlass abre un acceso de emergencia al expediente de un paciente con el que el médico no tiene
+// relación de atención. Exige un motivo, dura BREAK_GLASS_MINUTES y se notifica al paciente y a cumplimiento.
+func BreakGlass(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	role, _ := c.Locals("role").(string)
+
+	idPaciente, err := c.ParamsInt("id")
+	if err != nil || idPaciente <= 0 {
+		utils.LogAction(userID, "break_glass", "fallido", "ID de paciente inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de paciente inválido"})
+	}
+	var input struct {
+		Motivo string `json:"motivo"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "break_glass", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	// En una sola línea: el motivo va al registro de auditoría, que es de una entrada por línea
+	motivo := strings.Join(strings.Fields(input.Motivo), " ")
+	if utf8.RuneCountInString(motivo) < breakGlassMinReason {
+		utils.LogAction(userID, "break_glass", "fallido", "Motivo insuficiente para paciente ID "+strconv.Itoa(idPaciente))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Describe el motivo de la emergencia (mínimo " + strconv.Itoa(breakGlassMinReason) + " caracteres)",
+		})
+	}
+	ctx := context.Background()
+
+	var pacienteCorreo, pacienteNombre string
+	err = config.Conn.QueryRow(ctx,
+		`SELECT u.correo, u.nombre FROM pacientes p
+		JOIN usuarios u ON u.id_usuario = p.id_usuario WHERE p.id_paciente = $1`,
+		idPaciente).Scan(&pacienteCorreo, &pacienteNombre)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "break_glass", "fallido", "Paciente no encontrado: ID "+strconv.Itoa(idPaciente))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Paciente no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar paciente: %v", err)
+		utils.LogAction(userID, "break_glass", "fallido", "Error al consultar paciente: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al abrir acceso de emergencia"})
+	}
+
+	grant, err := access.PatientAccess(ctx, userID, role, idPaciente)
+	if err != nil {
+		log.Printf("Error al evaluar acceso a paciente: %v", err)
+		utils.LogAction(userID, "break_glass", "fallido", "Error al evaluar acceso: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al abrir acceso de emergencia"})
+	}
+	if grant.Allowed {
+		utils.LogAction(userID, "break_glass", "fallido", "Ya tiene relación de atención con paciente ID "+strconv.Itoa(idPaciente))
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Ya tienes acceso a este paciente por relación de atención"})
+	}
+
+	var medico string
+	if err := config.Conn.QueryRow(ctx,
+		"SELECT nombre || ' ' || apellido FROM usuarios WHERE id_usuario = $1", userID).Scan(&medico); err != nil {
+		log.Printf("Error al consultar médico: %v", err)
+		utils.LogAction(userID, "break_glass", "fallido", "Error al consultar médico: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al abrir acceso de emergencia"})
+	}
+
+	var id int
+	var expiresAt time.Time
+	err = config.Conn.QueryRow(ctx,
+		"INSERT INTO break_glass_access (id_usuario, id_paciente, motivo, ip, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id_acceso, expires_at",
+		userID, idPaciente, motivo, c.IP(), time.Now().Add(breakGlassTTL())).Scan(&id, &expiresAt)
+	if err != nil {
+		log.Printf("Error al guardar acceso de emergencia: %v", err)
+		utils.LogAction(userID, "break_glass", "fallido", "Error al guardar acceso: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al abrir acceso de emergencia"})
+	}
+
+	hasta := expiresAt.Format("02/01/2006 15:04")
+	utils.LogAlert(userID, "break_glass", "exitoso", "Acceso de emergencia #"+strconv.Itoa(id)+" de "+medico+
+		" al expediente del paciente ID "+strconv.Itoa(idPaciente)+" hasta "+expiresAt.Format(time.RFC3339)+"; motivo: "+motivo)
+
+	sendMailAsync(userID, "break_glass", mailer.Message{
+		To:      pacienteCorreo,
+		Subject: "Acceso de emergencia a tu expediente",
+		Body: "Hola " + pacienteNombre + ",\n\n" +
+			"El Dr./Dra. " + medico + " abrió un acceso de emergencia a tu expediente clínico, válido hasta el " + hasta + ".\n" +
+			"Motivo declarado: " + motivo + "\n\n" +
+			"El acceso queda registrado y será revisado por el equipo de cumplimiento. Si tienes dudas, comunícate con el hospital.",
+	})
+	for _, to := range complianceRecipients() {
+		sendMailAsync(userID, "break_glass", mailer.Message{
+			To:      to,
+			Subject: "Revisión requerida: acceso de emergencia #" + strconv.Itoa(id),
+			Body: "Médico: " + medico + " (usuario " + strconv.Itoa(userID) + ")\n" +
+				"Paciente: ID " + strconv.Itoa(idPaciente) + "\n" +
+				"Motivo: " + motivo + "\n" +
+				"IP: " + c.IP() + "\n" +
+				"Vigente hasta: " + hasta + "\n\n" +
+				"Consulta los accesos de emergencia en GET /admin/break-glass.",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id":         id,
+		"expires_at": expiresAt,
+		"message":    "Acceso de emergencia concedido al expediente; queda registrado y se notificó al paciente",
+	})
+}
+
+// ListBreakGlassAccess lista los accesos de emergencia para su revisión, los más recientes primero.
+// Filtros opcionales: id_paciente, id_usuario y vigente=true.
+func ListBreakGlassAccess(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var conditions []string
+	var args []interface{}
+	for _, filter := range []string{"id_paciente", "id_usuario"} {
+		if value := c.Query(filter); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				utils.LogAction(userID, "list_break_glass", "fallido", "Filtro "+filter+" inválido: "+value)
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "El filtro " + filter + " debe ser numérico"})
+			}
+			args = append(args, id)
+			conditions = append(conditions, "b."+filter+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	if c.QueryBool("vigente") {
+		conditions = append(conditions, "b.expires_at > now()")
+	}
+
+	limit := c.QueryInt("limit", defaultUserListLimit)
+	if limit <= 0 || limit > maxUserListLimit {
+		limit = defaultUserListLimit
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := `SELECT b.id_acceso, b.id_usuario, um.nombre || ' ' || um.apellido, b.id_paciente, up.nombre || ' ' || up.apellido,
+		b.motivo, COALESCE(b.ip, ''), b.created_at, b.expires_at, b.expires_at > now(), count(*) OVER ()
+		FROM break_glass_access b
+		JOIN usuarios um ON um.id_usuario = b.id_usuario
+		JOIN pacientes p ON p.id_paciente = b.id_paciente
+		JOIN usuarios up ON up.id_usuario = p.id_usuario`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += " ORDER BY b.created_at DESC LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := config.Conn.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Printf("Error al listar accesos de emergencia: %v", err)
+		utils.LogAction(userID, "list_break_glass", "fallido", "Error al listar accesos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar accesos de emergencia"})
+	}
+	defer rows.Close()
+
+	accesses := []BreakGlassAccess{}
+	total := 0
+	for rows.Next() {
+		var a BreakGlassAccess
+		if err := rows.Scan(&a.ID, &a.IDUsuario, &a.Medico, &a.IDPaciente, &a.Paciente,
+			&a.Motivo, &a.IP, &a.CreatedAt, &a.ExpiresAt, &a.Vigente, &total); err != nil {
+			log.Printf("Error al leer acceso de emergencia: %v", err)
+			utils.LogAction(userID, "list_break_glass", "fallido", "Error al leer acceso: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar accesos de emergencia"})
+		}
+		accesses = append(accesses, a)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error al listar accesos de emergencia: %v", err)
+		utils.LogAction(userID, "list_break_glass", "fallido", "Error al listar accesos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar accesos de emergencia"})
+	}
+
+	utils.LogAction(userID, "list_break_glass", "exitoso", "Accesos de emergencia listados: "+strconv.Itoa(len(accesses))+" de "+strconv.Itoa(total))
+	return c.JSON(fiber.Map{
+		"accesos": accesses,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
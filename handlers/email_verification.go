@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/utils"
+)
+
+const (
+	emailVerificationTTL            = 48 * time.Hour
+	emailVerificationResendInterval = time.Minute
+	emailVerificationMaxPerDay      = 5
+)
+
+// Tipos de token de verificación: el del registro confirma el correo de la cuenta; el de cambio
+// confirma un correo nuevo pedido desde PATCH /profile, que solo se aplica al verificarlo.
+const (
+	emailTokenRegistro = "registro"
+	emailTokenCambio   = "cambio_correo"
+)
+
+// issueEmailVerification invalida los tokens pendientes de userID y crea uno nuevo para correo.
+func issueEmailVerification(ctx context.Context, db execer, userID int, correo string) (string, error) {
+	return issueEmailToken(ctx, db, userID, correo, emailTokenRegistro)
+}
+
+// issueEmailChange crea el token que confirma correo como nuevo correo de userID.
+func issueEmailChange(ctx context.Context, db execer, userID int, correo string) (string, error) {
+	return issueEmailToken(ctx, db, userID, correo, emailTokenCambio)
+}
+
+func issueEmailToken(ctx context.Context, db execer, userID int, correo, tipo string) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(ctx,
+		"UPDATE email_verification_tokens SET used_at = now() WHERE id_usuario = $1 AND used_at IS NULL", userID); err != nil {
+		return "", err
+	}
+	_, err = db.Exec(ctx,
+		"INSERT INTO email_verification_tokens (id_usuario, correo, token_hash, expires_at, tipo) VALUES ($1, $2, $3, $4, $5)",
+		userID, correo, hashToken(token), time.Now().Add(emailVerificationTTL), tipo)
+	return token, err
+}
+
+func sendVerificationEmail(userID int, correo, token string) {
+	link := frontendLink("EMAIL_VERIFICATION_URL", "http://localhost:3000/verificar-correo", token)
+	sendMailAsync(userID, "send_email_verification", mailer.Message{
+		To:      correo,
+		Subject: "Verifica tu correo",
+		Body: "Para activar tu cuenta confirma tu dirección de correo abriendo el siguiente enlace:\n" + link + "\n\n" +
+			"El enlace caduca en 48 horas. Si no creaste esta cuenta, ignora este mensaje.",
+	})
+}
+
+// VerifyEmail marca como verificado el correo asociado al token, siempre que siga siendo el correo de la cuenta.
+// Si el token es de un cambio de correo, el correo nuevo pasa a ser el de la cuenta.
+func VerifyEmail(c *fiber.Ctx) error {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "verify_email", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(0, "verify_email", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al verificar correo"})
+	}
+	defer tx.Rollback(ctx)
+
+	var idVerificacion, userID int
+	var correo, tipo string
+	err = tx.QueryRow(ctx,
+		"SELECT id_verificacion, id_usuario, correo, tipo FROM email_verification_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now() FOR UPDATE",
+		hashToken(input.Token)).Scan(&idVerificacion, &userID, &correo, &tipo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(0, "verify_email", "fallido", "Token de verificación inválido, usado o expirado")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Enlace inválido o expirado"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar token de verificación: %v", err)
+		utils.LogAction(0, "verify_email", "fallido", "Error al consultar token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al verificar correo"})
+	}
+
+	query := "UPDATE usuarios SET correo_verificado = true WHERE id_usuario = $1 AND correo = $2"
+	if tipo == emailTokenCambio {
+		query = "UPDATE usuarios SET correo = $2, correo_verificado = true WHERE id_usuario = $1"
+	}
+	result, err := tx.Exec(ctx, query, userID, correo)
+	if isUniqueViolation(err) {
+		utils.LogAction(userID, "verify_email", "fallido", "El correo nuevo ya pertenece a otra cuenta: "+correo)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El correo ya está registrado"})
+	}
+	if err != nil {
+		utils.LogAction(userID, "verify_email", "fallido", "Error al verificar correo: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al verificar correo"})
+	}
+	if result.RowsAffected() == 0 {
+		utils.LogAction(userID, "verify_email", "fallido", "El correo de la cuenta cambió desde que se emitió el token")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Enlace inválido o expirado"})
+	}
+	if _, err := tx.Exec(ctx, "UPDATE email_verification_tokens SET used_at = now() WHERE id_verificacion = $1", idVerificacion); err != nil {
+		utils.LogAction(userID, "verify_email", "fallido", "Error al consumir token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al verificar correo"})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.LogAction(userID, "verify_email", "fallido", "Error al confirmar verificación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al verificar correo"})
+	}
+
+	if tipo == emailTokenCambio {
+		utils.LogAction(userID, "verify_email", "exitoso", "Cambio de correo confirmado: "+correo)
+		return c.JSON(fiber.Map{"message": "Correo actualizado", "correo": correo})
+	}
+	utils.LogAction(userID, "verify_email", "exitoso", "Correo verificado: "+correo)
+	return c.JSON(fiber.Map{"message": "Correo verificado"})
+}
+
+// ResendEmailVerification reenvía el enlace de verificación. Se limita a un envío por minuto y
+// emailVerificationMaxPerDay al día por cuenta, y responde igual exista o no la cuenta.
+func ResendEmailVerification(c *fiber.Ctx) error {
+	var input struct {
+		Correo string `json:"correo"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "resend_email_verification", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	input.Correo = normalizeEmail(input.Correo)
+	response := fiber.Map{"message": "Si la cuenta existe y no está verificada, recibirás un nuevo enlace"}
+
+	ctx := context.Background()
+	var userID int
+	var verified bool
+	err := config.Conn.QueryRow(ctx,
+		"SELECT id_usuario, correo_verificado FROM usuarios WHERE lower(correo) = $1", input.Correo).Scan(&userID, &verified)
+	if err != nil {
+		utils.LogAction(0, "resend_email_verification", "fallido", "Correo no encontrado o error en consulta: "+err.Error())
+		return c.JSON(response)
+	}
+	if verified {
+		utils.LogAction(userID, "resend_email_verification", "fallido", "El correo ya está verificado")
+		return c.JSON(response)
+	}
+
+	var lastMinute, lastDay int
+	err = config.Conn.QueryRow(ctx,
+		`SELECT count(*) FILTER (WHERE created_at > now() - $2 * interval '1 second'), count(*)
+		FROM email_verification_tokens WHERE id_usuario = $1 AND created_at > now() - interval '1 day'`,
+		userID, int(emailVerificationResendInterval.Seconds())).Scan(&lastMinute, &lastDay)
+	if err != nil {
+		log.Printf("Error al consultar envíos de verificación: %v", err)
+		utils.LogAction(userID, "resend_email_verification", "fallido", "Error al consultar envíos: "+err.Error())
+		return c.JSON(response)
+	}
+	if lastMinute > 0 || lastDay >= emailVerificationMaxPerDay {
+		// Misma respuesta que sin límite: un 429 revelaría qué correos tienen cuenta
+		utils.LogAction(userID, "resend_email_verification", "fallido", "Reenvío limitado por frecuencia")
+		return c.JSON(response)
+	}
+
+	token, err := issueEmailVerification(ctx, config.Conn, userID, input.Correo)
+	if err != nil {
+		log.Printf("Error al generar token de verificación: %v", err)
+		utils.LogAction(userID, "resend_email_verification", "fallido", "Error al generar token: "+err.Error())
+		return c.JSON(response)
+	}
+	sendVerificationEmail(userID, input.Correo, token)
+	utils.LogAction(userID, "resend_email_verification", "exitoso", "Enlace de verificación reenviado")
+	return c.JSON(response)
+}
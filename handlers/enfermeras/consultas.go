@@ -2,28 +2,27 @@ package enfermeras
 
 import (
 	"context"
+	"errors"
 	"log"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
 	"hospitalaria/config"
 	"hospitalaria/utils"
 )
 
+// AssignConsulta asigna una enfermera a la consulta de una cita aceptada. La asignación da a la
+// enfermera acceso al expediente del paciente (ver paquete access), así que la hace el médico de
+// la cita o un administrador, nunca la propia enfermera. Paciente, médico y fecha se copian de la cita.
 func AssignConsulta(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
-    log.Printf("Solicitud recibida para userID: %d", userID)
     role := c.Locals("role").(string)
-    if role != "Enfermero" {
-        utils.LogAction(userID, "assign_consulta", "fallido", "Permiso denegado: Solo Enfermeras pueden asignar consultas")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
+    log.Printf("Solicitud recibida para userID: %d", userID)
 
     type ConsultaInput struct {
         IDCita       int    `json:"id_cita"`
-        IDPaciente   int    `json:"id_paciente"`
-        IDMedico     int    `json:"id_medico"`
-        FechaHora    string `json:"fecha_hora"`
+        IDEnfermera  int    `json:"id_enfermera"`
         Diagnostico  string `json:"diagnostico,omitempty"`
         Estado       string `json:"estado"`
     }
@@ -33,23 +32,48 @@ func AssignConsulta(c *fiber.Ctx) error {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
     }
 
-    var idEnfermera int
-    err := config.Conn.QueryRow(context.Background(), "SELECT id_enfermera FROM enfermeras WHERE id_usuario = $1", userID).Scan(&idEnfermera)
+    ctx := context.Background()
+    var medicoUserID int
+    err := config.Conn.QueryRow(ctx,
+        "SELECT m.id_usuario FROM citas c JOIN medicos m ON m.id_medico = c.id_medico WHERE c.id_cita = $1 AND c.estado = 'aceptada'",
+        input.IDCita).Scan(&medicoUserID)
+    if errors.Is(err, pgx.ErrNoRows) {
+        utils.LogAction(userID, "assign_consulta", "fallido", "Cita inexistente o no aceptada: ID "+strconv.Itoa(input.IDCita))
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "La cita no existe o no está aceptada"})
+    }
     if err != nil {
-        utils.LogAction(userID, "assign_consulta", "fallido", "Enfermera no encontrada: "+err.Error())
+        log.Printf("Error al validar cita: %v", err)
+        utils.LogAction(userID, "assign_consulta", "fallido", "Error al validar cita: "+err.Error())
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al asignar consulta"})
+    }
+    if role != "Administrador" && medicoUserID != userID {
+        utils.LogAction(userID, "assign_consulta", "fallido", "La cita es de otro médico: ID "+strconv.Itoa(input.IDCita))
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Solo el médico de la cita o un administrador pueden asignar la consulta"})
+    }
+
+    var enfermeraExiste bool
+    err = config.Conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM enfermeras WHERE id_enfermera = $1)", input.IDEnfermera).Scan(&enfermeraExiste)
+    if err != nil {
+        log.Printf("Error al consultar enfermera: %v", err)
+        utils.LogAction(userID, "assign_consulta", "fallido", "Error al consultar enfermera: "+err.Error())
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al asignar consulta"})
+    }
+    if !enfermeraExiste {
+        utils.LogAction(userID, "assign_consulta", "fallido", "Enfermera no encontrada: ID "+strconv.Itoa(input.IDEnfermera))
         return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Enfermera no encontrada"})
     }
 
-    log.Printf("Datos a insertar: id_cita=%d, id_paciente=%d, id_medico=%d, id_enfermera=%d, fecha_hora=%s, diagnostico=%s, estado=%s",
-        input.IDCita, input.IDPaciente, input.IDMedico, idEnfermera, input.FechaHora, input.Diagnostico, input.Estado)
-    _, err = config.Conn.Exec(context.Background(),
-        "INSERT INTO consultas (id_cita, id_paciente, id_medico, id_enfermera, fecha_hora, diagnostico, estado) VALUES ($1, $2, $3, $4, $5, $6, $7)",
-        input.IDCita, input.IDPaciente, input.IDMedico, idEnfermera, input.FechaHora, input.Diagnostico, input.Estado)
+    log.Printf("Datos a insertar: id_cita=%d, id_enfermera=%d, diagnostico=%s, estado=%s",
+        input.IDCita, input.IDEnfermera, input.Diagnostico, input.Estado)
+    _, err = config.Conn.Exec(ctx,
+        `INSERT INTO consultas (id_cita, id_paciente, id_medico, id_enfermera, fecha_hora, diagnostico, estado)
+        SELECT id_cita, id_paciente, id_medico, $2, fecha_hora, $3, $4 FROM citas WHERE id_cita = $1`,
+        input.IDCita, input.IDEnfermera, input.Diagnostico, input.Estado)
     if err != nil {
         log.Printf("Error al asignar consulta: %v", err)
         utils.LogAction(userID, "assign_consulta", "fallido", "Error al asignar consulta: "+err.Error())
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al asignar consulta: " + err.Error()})
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al asignar consulta"})
     }
-    utils.LogAction(userID, "assign_consulta", "exitoso", "Consulta asignada para enfermera ID "+strconv.Itoa(idEnfermera))
+    utils.LogAction(userID, "assign_consulta", "exitoso", "Consulta de la cita ID "+strconv.Itoa(input.IDCita)+" asignada a enfermera ID "+strconv.Itoa(input.IDEnfermera))
     return c.JSON(fiber.Map{"message": "Consulta asignada", "estado": input.Estado})
-}
\ No newline at end of file
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"hospitalaria/config"
+	"hospitalaria/secretbox"
+)
+
+// fakeDB sustituye a config.Conn en las pruebas. Cada consulta se resuelve con el primer
+// manejador cuyo fragmento de SQL aparece en ella; una consulta sin manejador hace fallar la prueba.
+type fakeDB struct {
+	t        *testing.T
+	mu       sync.Mutex
+	handlers []fakeHandler
+}
+
+type fakeHandler struct {
+	fragment string
+	fn       func(args []interface{}) fakeResult
+}
+
+// fakeResult es la respuesta a una consulta: filas para Query/QueryRow y filas afectadas para Exec.
+type fakeResult struct {
+	rows     [][]interface{}
+	affected int64
+	err      error
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	db := &fakeDB{t: t}
+	prev := config.Conn
+	config.Conn = db
+	t.Cleanup(func() { config.Conn = prev })
+	return db
+}
+
+func (db *fakeDB) on(fragment string, fn func(args []interface{}) fakeResult) {
+	db.handlers = append(db.handlers, fakeHandler{fragment: fragment, fn: fn})
+}
+
+func (db *fakeDB) run(sql string, args []interface{}) fakeResult {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, h := range db.handlers {
+		if strings.Contains(sql, h.fragment) {
+			return h.fn(args)
+		}
+	}
+	db.t.Errorf("consulta inesperada: %s", sql)
+	return fakeResult{err: errors.New("consulta inesperada en fakeDB")}
+}
+
+func (db *fakeDB) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	r := db.run(sql, args)
+	return pgconn.CommandTag("FAKE " + strconv.FormatInt(r.affected, 10)), r.err
+}
+
+func (db *fakeDB) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	r := db.run(sql, args)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &fakeRows{rows: r.rows, idx: -1}, nil
+}
+
+func (db *fakeDB) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	r := db.run(sql, args)
+	switch {
+	case r.err != nil:
+		return fakeRow{err: r.err}
+	case len(r.rows) == 0:
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+	return fakeRow{values: r.rows[0]}
+}
+
+// Begin devuelve una transacción que ejecuta sobre el mismo fakeDB; Rollback no deshace nada.
+func (db *fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	return &fakeTx{db: db}, nil
+}
+
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return tx.db.Query(ctx, sql, args...)
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Commit(context.Context) error   { return nil }
+func (tx *fakeTx) Rollback(context.Context) error { return nil }
+
+func (db *fakeDB) Close() {}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return scanValues(r.values, dest)
+}
+
+type fakeRows struct {
+	rows [][]interface{}
+	idx  int
+}
+
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag {
+	return pgconn.CommandTag("SELECT " + strconv.Itoa(len(r.rows)))
+}
+func (r *fakeRows) FieldDescriptions() []pgproto3.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                            { return nil }
+
+func (r *fakeRows) Next() bool {
+	r.idx++
+	return r.idx < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	return scanValues(r.rows[r.idx], dest)
+}
+
+func (r *fakeRows) Values() ([]interface{}, error) {
+	return r.rows[r.idx], nil
+}
+
+// scanValues copia values en dest como lo haría pgx para los tipos usados por los handlers:
+// nil deja el valor cero y un valor se envuelve en puntero si el destino lo es.
+func scanValues(values []interface{}, dest []interface{}) error {
+	if len(values) != len(dest) {
+		return fmt.Errorf("fakeDB: %d valores para %d destinos", len(values), len(dest))
+	}
+	for i, value := range values {
+		dv := reflect.ValueOf(dest[i])
+		if dv.Kind() != reflect.Ptr || dv.IsNil() {
+			return fmt.Errorf("fakeDB: destino %d no es un puntero", i)
+		}
+		dv = dv.Elem()
+		if value == nil {
+			dv.Set(reflect.Zero(dv.Type()))
+			continue
+		}
+		v := reflect.ValueOf(value)
+		target := dv
+		if dv.Kind() == reflect.Ptr && !v.Type().AssignableTo(dv.Type()) {
+			target = reflect.New(dv.Type().Elem()).Elem()
+		}
+		if !v.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("fakeDB: no se puede asignar %T al destino %d (%s)", value, i, target.Type())
+		}
+		target.Set(v)
+		if target != dv {
+			dv.Set(target.Addr())
+		}
+	}
+	return nil
+}
+
+// useTestJWTKeys configura config.JWTKeys con una clave Ed25519 temporal.
+func useTestJWTKeys(t *testing.T) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "test.pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := config.LoadKeySet(dir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := config.JWTKeys
+	config.JWTKeys = keys
+	t.Cleanup(func() { config.JWTKeys = prev })
+}
+
+// useTestTOTP configura los parámetros TOTP por defecto y un llavero de secretos temporal.
+func useTestTOTP(t *testing.T) {
+	t.Helper()
+	keyring, err := secretbox.NewKeyring(map[string][]byte{"test": make([]byte, 32)}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevParams, prevSecrets := config.TOTP, config.TOTPSecrets
+	config.TOTP = config.TOTPParams{Period: 30, Digits: otp.DigitsSix, Skew: 1}
+	config.TOTPSecrets = keyring
+	t.Cleanup(func() { config.TOTP, config.TOTPSecrets = prevParams, prevSecrets })
+}
+
+// totpCodeNow devuelve el código TOTP vigente de testTOTPSeed.
+func totpCodeNow(t *testing.T) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSeed, time.Now(),
+		totp.ValidateOpts{Period: 30, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// withUser simula JWTProtected dejando userID y role en Locals.
+func withUser(userID int, role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user_id", userID)
+		c.Locals("role", role)
+		return c.Next()
+	}
+}
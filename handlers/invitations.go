@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/pquerna/otp/totp"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/models"
+	"hospitalaria/passwordpolicy"
+	"hospitalaria/utils"
+)
+
+const invitationTokenType = "staff_invitation"
+
+// invitableRoles son los roles que solo pueden obtenerse mediante invitación.
+var invitableRoles = map[string]bool{
+	"Medico":        true,
+	"Enfermero":     true,
+	"Administrador": true,
+}
+
+func invitationTTL() time.Duration {
+	return time.Duration(utils.GetEnvInt("STAFF_INVITATION_TTL_HOURS", 72)) * time.Hour
+}
+
+// signInvitationToken firma la invitación ligada a correo y rol. jti identifica la fila en staff_invitations.
+func signInvitationToken(jti, correo, rol string, expiresAt time.Time) (string, error) {
+	return config.JWTKeys.Sign(jwt.MapClaims{
+		"jti":        jti,
+		"correo":     correo,
+		"rol":        rol,
+		"token_type": invitationTokenType,
+		"exp":        expiresAt.Unix(),
+	})
+}
+
+// CreateInvitation emite una invitación de alta para personal y la envía por correo.
+func CreateInvitation(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		Correo string `json:"correo"`
+		Rol    string `json:"rol"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "create_invitation", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	input.Correo = normalizeEmail(input.Correo)
+	if !validEmail(input.Correo) || !invitableRoles[input.Rol] {
+		utils.LogAction(userID, "create_invitation", "fallido", "Correo inválido o rol no invitable: "+input.Rol)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Se requiere un correo válido y un rol válido (Medico, Enfermero o Administrador)"})
+	}
+
+	ctx := context.Background()
+	var exists bool
+	if err := config.Conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM usuarios WHERE lower(correo) = $1)", input.Correo).Scan(&exists); err != nil {
+		log.Printf("Error al consultar usuario: %v", err)
+		utils.LogAction(userID, "create_invitation", "fallido", "Error al consultar usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear invitación"})
+	}
+	if exists {
+		utils.LogAction(userID, "create_invitation", "fallido", "Correo ya registrado: "+input.Correo)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El correo ya está registrado"})
+	}
+
+	jti := uuid.NewString()
+	expiresAt := time.Now().Add(invitationTTL())
+	token, err := signInvitationToken(jti, input.Correo, input.Rol, expiresAt)
+	if err != nil {
+		log.Printf("Error al firmar invitación: %v", err)
+		utils.LogAction(userID, "create_invitation", "fallido", "Error al firmar invitación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear invitación"})
+	}
+
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "create_invitation", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear invitación"})
+	}
+	defer tx.Rollback(ctx)
+	// Solo la invitación más reciente para un correo es válida
+	_, err = tx.Exec(ctx,
+		"UPDATE staff_invitations SET revoked_at = now() WHERE correo = $1 AND accepted_at IS NULL AND revoked_at IS NULL",
+		input.Correo)
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO staff_invitations (jti, correo, rol, invitado_por, expires_at) VALUES ($1, $2, $3, $4, $5)",
+			jti, input.Correo, input.Rol, userID, expiresAt)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al guardar invitación: %v", err)
+		utils.LogAction(userID, "create_invitation", "fallido", "Error al guardar invitación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al crear invitación"})
+	}
+
+	link := frontendLink("STAFF_INVITATION_URL", "http://localhost:3000/invitacion", token)
+	sendMailAsync(userID, "create_invitation", mailer.Message{
+		To:      input.Correo,
+		Subject: "Invitación al sistema del hospital",
+		Body: "Has sido invitado a unirte al sistema del hospital como " + input.Rol + ".\n\n" +
+			"Para crear tu cuenta, definir tu contraseña y configurar tu autenticador abre el siguiente enlace antes del " +
+			expiresAt.Format("02/01/2006 15:04") + ":\n" + link + "\n\n" +
+			"Si no esperabas esta invitación, ignora este mensaje.",
+	})
+	utils.LogAction(userID, "create_invitation", "exitoso", "Invitación "+jti+" como "+input.Rol+" enviada a "+input.Correo)
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id_invitacion": jti,
+		"correo":        input.Correo,
+		"rol":           input.Rol,
+		"expires_at":    expiresAt.Format(time.RFC3339),
+	})
+}
+
+// RevokeInvitation anula una invitación pendiente.
+func RevokeInvitation(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	jti := c.Params("id")
+	result, err := config.Conn.Exec(context.Background(),
+		"UPDATE staff_invitations SET revoked_at = now() WHERE jti = $1 AND accepted_at IS NULL AND revoked_at IS NULL", jti)
+	if err != nil {
+		log.Printf("Error al revocar invitación: %v", err)
+		utils.LogAction(userID, "revoke_invitation", "fallido", "Error al revocar invitación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al revocar invitación"})
+	}
+	if result.RowsAffected() == 0 {
+		utils.LogAction(userID, "revoke_invitation", "fallido", "Invitación no encontrada o ya usada: "+jti)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invitación no encontrada o ya usada"})
+	}
+	utils.LogAction(userID, "revoke_invitation", "exitoso", "Invitación revocada: "+jti)
+	return c.JSON(fiber.Map{"message": "Invitación revocada"})
+}
+
+// AcceptInvitation crea la cuenta de personal de una invitación válida. El correo queda verificado y,
+// como en un reinicio TOTP forzado, la sesión se emite en /login/totp-enrollment tras confirmar el autenticador.
+func AcceptInvitation(c *fiber.Ctx) error {
+	var input struct {
+		Token           string `json:"token"`
+		Nombre          string `json:"nombre"`
+		Apellido        string `json:"apellido"`
+		Password        string `json:"password"`
+		Especialidad    string `json:"especialidad,omitempty"`
+		NumeroColegiado string `json:"numero_colegiado,omitempty"`
+		Certificacion   string `json:"certificacion,omitempty"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "accept_invitation", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	token, err := jwt.Parse(input.Token, config.JWTKeys.Keyfunc)
+	if err != nil || !token.Valid {
+		utils.LogAction(0, "accept_invitation", "fallido", "Token de invitación inválido o expirado")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invitación inválida o expirada"})
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	tokenType, _ := claims["token_type"].(string)
+	jti, _ := claims["jti"].(string)
+	if !ok || tokenType != invitationTokenType || jti == "" {
+		utils.LogAction(0, "accept_invitation", "fallido", "Token de invitación inválido")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invitación inválida o expirada"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(0, "accept_invitation", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al aceptar invitación"})
+	}
+	defer tx.Rollback(ctx)
+
+	// El correo y el rol se toman de la fila, no del cuerpo de la petición
+	var correo, rol string
+	err = tx.QueryRow(ctx,
+		"SELECT correo, rol FROM staff_invitations WHERE jti = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now() FOR UPDATE",
+		jti).Scan(&correo, &rol)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(0, "accept_invitation", "fallido", "Invitación usada, revocada o expirada: "+jti)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invitación inválida o expirada"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar invitación: %v", err)
+		utils.LogAction(0, "accept_invitation", "fallido", "Error al consultar invitación: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al aceptar invitación"})
+	}
+
+	violations := CheckPasswordStrength(input.Password, passwordpolicy.UserInfo{
+		Nombre: input.Nombre, Apellido: input.Apellido, Correo: correo,
+	})
+	if len(violations) > 0 {
+		return respondWeakPassword(c, 0, "accept_invitation", violations)
+	}
+	hash, err := hashPassword(input.Password)
+	if err != nil {
+		log.Printf("Error al hashear contraseña: %v", err)
+		utils.LogAction(0, "accept_invitation", "fallido", "Error al hashear contraseña: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al procesar contraseña"})
+	}
+	key, err := totp.Generate(currentTOTPConfig().generateOpts(correo))
+	if err != nil {
+		log.Printf("Error al generar secreto TOTP: %v", err)
+		utils.LogAction(0, "accept_invitation", "fallido", "Error al generar TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código TOTP"})
+	}
+	// El secreto queda pendiente y totp_reset_required activo hasta confirmar un código. Se cifra
+	// ligado al id del usuario, así que se guarda tras el INSERT
+	var userID int
+	err = tx.QueryRow(ctx,
+		`INSERT INTO usuarios (nombre, apellido, correo, contraseña, rol, totp_secret, totp_reset_required, correo_verificado)
+		VALUES ($1, $2, $3, $4, $5, '', true, true) RETURNING id_usuario`,
+		input.Nombre, input.Apellido, correo, hash, rol).Scan(&userID)
+	if isUniqueViolation(err) {
+		utils.LogAction(0, "accept_invitation", "fallido", "Correo ya registrado: "+correo)
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El correo ya está registrado"})
+	}
+	if err == nil {
+		var sealedSecret string
+		sealedSecret, err = sealTOTPSecret(userID, key.Secret())
+		if err == nil {
+			_, err = tx.Exec(ctx,
+				"UPDATE usuarios SET totp_secret = $2, totp_pending_secret = $2, totp_pending_at = now() WHERE id_usuario = $1",
+				userID, sealedSecret)
+		}
+	}
+	if err == nil {
+		err = insertRoleData(ctx, tx, userID, rol, models.User{
+			Especialidad: input.Especialidad, NumeroColegiado: input.NumeroColegiado, Certificacion: input.Certificacion,
+		})
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE staff_invitations SET accepted_at = now(), id_usuario = $2 WHERE jti = $1", jti, userID)
+	}
+	var recoveryCodes []string
+	if err == nil {
+		recoveryCodes, err = replaceRecoveryCodes(ctx, tx, userID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al crear cuenta invitada: %v", err)
+		utils.LogAction(0, "accept_invitation", "fallido", "Error al crear cuenta: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al aceptar invitación"})
+	}
+	utils.LogAction(userID, "accept_invitation", "exitoso", "Cuenta de "+rol+" creada desde invitación "+jti+" con ID "+strconv.Itoa(userID))
+
+	totpQR, err := totpQRDataURI(key)
+	if err != nil {
+		log.Printf("Error al generar código QR: %v", err)
+		utils.LogAction(userID, "accept_invitation", "fallido", "Error al generar QR: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar código QR"})
+	}
+	enrollmentToken, err := signEnrollmentToken(userID)
+	if err != nil {
+		log.Printf("Error al firmar token de inscripción: %v", err)
+		utils.LogAction(userID, "accept_invitation", "fallido", "Error al firmar token de inscripción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al aceptar invitación"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id_usuario":               userID,
+		"correo":                   correo,
+		"rol":                      rol,
+		"totp_enrollment_required": true,
+		"enrollment_token":         enrollmentToken,
+		"totp_qr":                  totpQR,
+		// Se muestran una única vez; solo se guarda su hash
+		"recovery_codes": recoveryCodes,
+	})
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	failureScopeAccount = "cuenta"
+	failureScopeIP      = "ip"
+	maxLoginDelay       = 30 * time.Second
+)
+
+// lockoutPolicy agrupa los umbrales de bloqueo; se leen del entorno en cada intento.
+type lockoutPolicy struct {
+	maxAccountFailures int
+	maxIPFailures      int
+	window             time.Duration
+	lockout            time.Duration
+}
+
+func currentLockoutPolicy() lockoutPolicy {
+	return lockoutPolicy{
+		maxAccountFailures: utils.GetEnvInt("LOGIN_MAX_FAILURES", 5),
+		maxIPFailures:      utils.GetEnvInt("LOGIN_MAX_FAILURES_IP", 20),
+		window:             time.Duration(utils.GetEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		lockout:            time.Duration(utils.GetEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+func (p lockoutPolicy) maxFailures(scope string) int {
+	if scope == failureScopeIP {
+		return p.maxIPFailures
+	}
+	return p.maxAccountFailures
+}
+
+// loginBlock describe por qué se rechaza un intento antes de comprobar credenciales.
+type loginBlock struct {
+	status      int
+	retryAfter  time.Duration
+	lockedUntil time.Time
+}
+
+// loginDelay devuelve la espera progresiva tras n fallos consecutivos: 1s, 2s, 4s... hasta maxLoginDelay.
+func loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	if failures > 6 {
+		return maxLoginDelay
+	}
+	delay := time.Second << (failures - 1)
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+func lockedStatus(scope string) int {
+	if scope == failureScopeAccount {
+		return fiber.StatusLocked
+	}
+	return fiber.StatusTooManyRequests
+}
+
+// checkLoginThrottle comprueba si subject está bloqueado o debe esperar antes de un nuevo intento.
+func checkLoginThrottle(ctx context.Context, scope, subject string, policy lockoutPolicy) (*loginBlock, error) {
+	var (
+		failures      int
+		lastFailureAt time.Time
+		lockedUntil   *time.Time
+	)
+	err := config.Conn.QueryRow(ctx,
+		"SELECT failures, last_failure_at, locked_until FROM login_failures WHERE scope = $1 AND subject = $2",
+		scope, subject).Scan(&failures, &lastFailureAt, &lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if lockedUntil != nil && now.Before(*lockedUntil) {
+		return &loginBlock{status: lockedStatus(scope), retryAfter: lockedUntil.Sub(now), lockedUntil: *lockedUntil}, nil
+	}
+	// La espera progresiva solo aplica por cuenta; por IP únicamente hay bloqueo al superar el umbral
+	if scope != failureScopeAccount || now.Sub(lastFailureAt) > policy.window {
+		return nil, nil
+	}
+	if wait := loginDelay(failures) - now.Sub(lastFailureAt); wait > 0 {
+		return &loginBlock{status: fiber.StatusTooManyRequests, retryAfter: wait}, nil
+	}
+	return nil, nil
+}
+
+// recordLoginFailure incrementa el contador de subject y lo bloquea al alcanzar el umbral de la política.
+// Devuelve el bloqueo resultante, o nil si aún no se alcanzó.
+func recordLoginFailure(ctx context.Context, scope, subject string, policy lockoutPolicy) (*loginBlock, error) {
+	var failures int
+	err := config.Conn.QueryRow(ctx,
+		`INSERT INTO login_failures (scope, subject, failures, last_failure_at) VALUES ($1, $2, 1, now())
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failure_at < now() - $3 * interval '1 second'
+				THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = now()
+		RETURNING failures`,
+		scope, subject, int(policy.window.Seconds())).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+	if failures < policy.maxFailures(scope) {
+		return nil, nil
+	}
+
+	lockedUntil := time.Now().Add(policy.lockout)
+	_, err = config.Conn.Exec(ctx,
+		"UPDATE login_failures SET failures = 0, locked_until = $3 WHERE scope = $1 AND subject = $2",
+		scope, subject, lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return &loginBlock{status: lockedStatus(scope), retryAfter: policy.lockout, lockedUntil: lockedUntil}, nil
+}
+
+// checkCredentialThrottle comprueba el bloqueo de la IP y de la cuenta antes de verificar credenciales fuera de /login.
+func checkCredentialThrottle(ctx context.Context, c *fiber.Ctx, userID int) (*loginBlock, error) {
+	policy := currentLockoutPolicy()
+	block, err := checkLoginThrottle(ctx, failureScopeIP, c.IP(), policy)
+	if err != nil || block != nil {
+		return block, err
+	}
+	return checkLoginThrottle(ctx, failureScopeAccount, strconv.Itoa(userID), policy)
+}
+
+func resetLoginFailures(ctx context.Context, userID int) error {
+	_, err := config.Conn.Exec(ctx,
+		"DELETE FROM login_failures WHERE scope = $1 AND subject = $2", failureScopeAccount, strconv.Itoa(userID))
+	return err
+}
+
+// loginSucceeded reinicia los fallos de la cuenta tras un login correcto y descuenta uno de la IP. La IP
+// no se reinicia: detrás de un proxy o NAT la comparten muchos usuarios, y con un reinicio completo
+// quien tenga una cuenta propia podría borrar sus fallos con cada login; descontando uno, los logins
+// legítimos compensan los errores de los demás sin levantar un bloqueo activo.
+func loginSucceeded(ctx context.Context, c *fiber.Ctx, userID int) {
+	if err := resetLoginFailures(ctx, userID); err != nil {
+		log.Printf("Error al reiniciar intentos fallidos: %v", err)
+	}
+	_, err := config.Conn.Exec(ctx,
+		"UPDATE login_failures SET failures = greatest(failures - 1, 0) WHERE scope = $1 AND subject = $2",
+		failureScopeIP, c.IP())
+	if err != nil {
+		log.Printf("Error al descontar intentos fallidos por IP: %v", err)
+	}
+}
+
+func respondLoginBlocked(c *fiber.Ctx, block *loginBlock) error {
+	retryAfter := int(block.retryAfter.Seconds() + 0.5)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	if block.status == fiber.StatusLocked {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error":        "Cuenta bloqueada temporalmente por intentos fallidos",
+			"locked_until": block.lockedUntil.Format(time.RFC3339),
+			"retry_after":  retryAfter,
+		})
+	}
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Demasiados intentos fallidos, espera antes de reintentar",
+		"retry_after": retryAfter,
+	})
+}
+
+// loginFailed registra un intento fallido para la IP y, si se conoce, para la cuenta.
+// Responde 423 si con este fallo la cuenta queda bloqueada y 401 con errMsg en caso contrario.
+func loginFailed(c *fiber.Ctx, userID int, errMsg, detail string) error {
+	return credentialFailed(c, userID, "login", errMsg, detail)
+}
+
+// credentialFailed es loginFailed para las comprobaciones de contraseña o segundo factor fuera de /login
+// (cambio de contraseña, cierre de cuenta...), auditadas como action. Comparten los contadores del login:
+// quien tenga un access token robado no puede probar contraseñas sin límite.
+func credentialFailed(c *fiber.Ctx, userID int, action, errMsg, detail string) error {
+	ctx := context.Background()
+	policy := currentLockoutPolicy()
+	utils.LogAction(userID, action, "fallido", detail)
+
+	if _, err := recordLoginFailure(ctx, failureScopeIP, c.IP(), policy); err != nil {
+		log.Printf("Error al registrar intento fallido por IP: %v", err)
+	}
+	if userID != 0 {
+		block, err := recordLoginFailure(ctx, failureScopeAccount, strconv.Itoa(userID), policy)
+		if err != nil {
+			log.Printf("Error al registrar intento fallido por cuenta: %v", err)
+		} else if block != nil {
+			utils.LogAction(userID, action, "fallido", "Cuenta bloqueada hasta "+block.lockedUntil.Format(time.RFC3339))
+			return respondLoginBlocked(c, block)
+		}
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": errMsg})
+}
+
+// UnlockAccount elimina el bloqueo y los intentos fallidos acumulados de una cuenta.
+func UnlockAccount(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	targetID, err := c.ParamsInt("id")
+	if err != nil || targetID <= 0 {
+		utils.LogAction(userID, "unlock_account", "fallido", "ID de usuario inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de usuario inválido"})
+	}
+
+	if err := resetLoginFailures(context.Background(), targetID); err != nil {
+		log.Printf("Error al desbloquear cuenta: %v", err)
+		utils.LogAction(userID, "unlock_account", "fallido", "Error al desbloquear cuenta: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al desbloquear cuenta"})
+	}
+	utils.LogAction(userID, "unlock_account", "exitoso", "Cuenta desbloqueada: ID "+strconv.Itoa(targetID))
+	return c.JSON(fiber.Map{"message": "Cuenta desbloqueada"})
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"log"
+	"net/mail"
+	"strings"
+
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/utils"
+)
+
+// sendMailAsync envía msg fuera del ciclo de la petición, para que el tiempo de respuesta
+// no revele si la cuenta existe; los errores solo se registran.
+func sendMailAsync(userID int, action string, msg mailer.Message) {
+	go func() {
+		if err := config.Mailer.Send(msg); err != nil {
+			log.Printf("Error al enviar correo a %s: %v", msg.To, err)
+			utils.LogAction(userID, action, "fallido", "Error al enviar correo: "+err.Error())
+		}
+	}()
+}
+
+// normalizeEmail es la forma en que se guardan y buscan los correos: sin espacios y en minúsculas,
+// para que Ana@Hospital.com y ana@hospital.com sean la misma cuenta.
+func normalizeEmail(correo string) string {
+	return strings.ToLower(strings.TrimSpace(correo))
+}
+
+// validEmail acepta solo una dirección simple (sin nombre ni <>) y sin saltos de línea, que
+// los remitentes escriben tal cual en la cabecera To.
+func validEmail(correo string) bool {
+	if strings.ContainsAny(correo, "\r\n") {
+		return false
+	}
+	addr, err := mail.ParseAddress(correo)
+	return err == nil && addr.Address == correo
+}
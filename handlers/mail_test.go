@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestValidEmail(t *testing.T) {
+	for correo, want := range map[string]bool{
+		"ana@hospital.com":                       true,
+		"ana.lopez+citas@hospital.com":           true,
+		"":                                       false,
+		"ana":                                    false,
+		"ana@":                                   false,
+		"Ana <ana@hospital.com>":                 false,
+		"ana@hospital.com\r\nBcc: otro@evil.com": false,
+		"ana@hospital.com\nSubject: falso":       false,
+		" ana@hospital.com":                      false,
+		"ana@hospital.com, otro@hospital.com":    false,
+	} {
+		if got := validEmail(correo); got != want {
+			t.Errorf("validEmail(%q) = %v, se esperaba %v", correo, got, want)
+		}
+	}
+}
+
+func TestCreateUserRejectsInvalidEmail(t *testing.T) {
+	newFakeDB(t) // ninguna consulta debe llegar a la base de datos
+	app := fiber.New()
+	app.Post("/register", CreateUser)
+	req := httptest.NewRequest("POST", "/register", bytes.NewBufferString(
+		`{"correo":"ana@hospital.com\r\nBcc: otro@evil.com","password":"Contraseña-Segura-123","nombre":"Ana","apellido":"López"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("estado %d, se esperaba 400", resp.StatusCode)
+	}
+}
+
+func TestCreateInvitationRejectsInvalidEmail(t *testing.T) {
+	newFakeDB(t) // ninguna consulta debe llegar a la base de datos
+	app := fiber.New()
+	app.Post("/admin/invitations", withUser(1, "Administrador"), CreateInvitation)
+	for _, correo := range []string{"", "medico@hospital.com\r\nBcc: otro@evil.com", "Dr. Pérez <medico@hospital.com>"} {
+		req := httptest.NewRequest("POST", "/admin/invitations", bytes.NewReader(mustJSON(t, map[string]string{
+			"correo": correo, "rol": "Medico",
+		})))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("correo %q: estado %d, se esperaba 400", correo, resp.StatusCode)
+		}
+	}
+}
+
+func TestLoginLooksUpNormalizedEmail(t *testing.T) {
+	var lookedUp interface{}
+	db := newFakeDB(t)
+	db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("INSERT INTO login_failures", func([]interface{}) fakeResult { return fakeResult{rows: [][]interface{}{{1}}} })
+	db.on("FROM usuarios WHERE lower(correo) = $1", func(args []interface{}) fakeResult {
+		lookedUp = args[0]
+		return fakeResult{}
+	})
+
+	app := fiber.New()
+	app.Post("/login", Login)
+	req := httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"correo":"  Ana@Hospital.COM ","password":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+	if lookedUp != "ana@hospital.com" {
+		t.Fatalf("correo buscado = %q, se esperaba el normalizado", lookedUp)
+	}
+}
@@ -12,11 +12,6 @@ import (
 
 func UpdateAppointment(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "update_appointment", "fallido", "Permiso denegado: Solo Médicos pueden aceptar citas")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type AppointmentUpdate struct {
         ID_cita int `json:"id_cita"`
@@ -14,11 +14,6 @@ import (
 func CreateConsultorio(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud recibida para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "create_consultorio", "fallido", "Permiso denegado: Solo Médicos pueden crear consultorios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type ConsultorioInput struct {
         NumeroConsultorio string `json:"numero_consultorio"`
@@ -55,11 +50,6 @@ func CreateConsultorio(c *fiber.Ctx) error {
 func GetConsultorios(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de lectura para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "read_consultorio", "fallido", "Permiso denegado: Solo Médicos pueden ver consultorios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     var idMedico int
     err := config.Conn.QueryRow(context.Background(), "SELECT id_medico FROM medicos WHERE id_usuario = $1", userID).Scan(&idMedico)
@@ -110,11 +100,6 @@ func GetConsultorios(c *fiber.Ctx) error {
 func UpdateConsultorio(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de actualización para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "update_consultorio", "fallido", "Permiso denegado: Solo Médicos pueden actualizar consultorios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type ConsultorioUpdate struct {
         IDConsultorio    int    `json:"id_consultorio"`
@@ -172,11 +157,6 @@ func UpdateConsultorio(c *fiber.Ctx) error {
 func DeleteConsultorio(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de eliminación para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "delete_consultorio", "fallido", "Permiso denegado: Solo Médicos pueden eliminar consultorios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type ConsultorioDelete struct {
         IDConsultorio int `json:"id_consultorio"`
@@ -14,11 +14,6 @@ import (
 func CreateHorario(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud recibida para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "create_horario", "fallido", "Permiso denegado: Solo Médicos pueden crear horarios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type HorarioInput struct {
         IDConsultorio int    `json:"id_consultorio"`
@@ -57,11 +52,6 @@ func CreateHorario(c *fiber.Ctx) error {
 func GetHorarios(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de lectura para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "read_horario", "fallido", "Permiso denegado: Solo Médicos pueden ver horarios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     var idMedico int
     err := config.Conn.QueryRow(context.Background(), "SELECT id_medico FROM medicos WHERE id_usuario = $1", userID).Scan(&idMedico)
@@ -108,11 +98,6 @@ func GetHorarios(c *fiber.Ctx) error {
 func UpdateHorario(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de actualización para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "update_horario", "fallido", "Permiso denegado: Solo Médicos pueden actualizar horarios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type HorarioUpdate struct {
         IDHorario    int    `json:"id_horario"`
@@ -182,11 +167,6 @@ func UpdateHorario(c *fiber.Ctx) error {
 func DeleteHorario(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de eliminación para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Medico" {
-        utils.LogAction(userID, "delete_horario", "fallido", "Permiso denegado: Solo Médicos pueden eliminar horarios")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type HorarioDelete struct {
         IDHorario int `json:"id_horario"`
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/oidc"
+	"hospitalaria/utils"
+)
+
+const (
+	// oidcStateTTL es el tiempo que tiene el usuario para autenticarse en el IdP.
+	oidcStateTTL = 10 * time.Minute
+	// oidcMaxPendingStates limita los logins sin terminar por IP, para que no se pueda llenar oidc_login_states.
+	oidcMaxPendingStates = 10
+	// oidcStateCookie ata el state al navegador que inició el login: un callback con el state de otra
+	// persona (login CSRF, para dejar a la víctima en la cuenta del atacante) no trae la cookie.
+	oidcStateCookie = "oidc_state"
+)
+
+var (
+	errOIDCStateMissing = errors.New("estado OIDC inexistente o expirado")
+	errOIDCUnknownUser  = errors.New("la identidad no corresponde a ninguna cuenta de personal")
+	errOIDCPatient      = errors.New("los pacientes no inician sesión con el IdP")
+)
+
+// oidcUser es la cuenta local vinculada a una identidad del IdP.
+type oidcUser struct {
+	ID     int
+	Rol    string
+	Correo string
+	Activo bool
+}
+
+// takeOIDCState consume el estado de un login; cada state solo puede usarse una vez.
+func takeOIDCState(ctx context.Context, state string) (verifier, nonce string, err error) {
+	err = config.Conn.QueryRow(ctx,
+		"DELETE FROM oidc_login_states WHERE state_hash = $1 AND expires_at > now() RETURNING code_verifier, nonce",
+		hashToken(state)).Scan(&verifier, &nonce)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", errOIDCStateMissing
+	}
+	return verifier, nonce, err
+}
+
+// StartOIDCStatePurge elimina periódicamente los estados OIDC expirados, que quedan de los logins
+// que nunca volvieron del IdP.
+func StartOIDCStatePurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := config.Conn.Exec(context.Background(), "DELETE FROM oidc_login_states WHERE expires_at < now()")
+			if err != nil {
+				log.Printf("Error al purgar estados OIDC: %v", err)
+				continue
+			}
+			if result.RowsAffected() > 0 {
+				log.Printf("Estados OIDC purgados: %d", result.RowsAffected())
+			}
+		}
+	}()
+}
+
+// setOIDCStateCookie guarda state en una cookie HttpOnly limitada a las rutas de login OIDC;
+// un value vacío la borra.
+func setOIDCStateCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(config.OIDC.RedirectURL, "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// checkOIDCThrottle rechaza el intento si la IP está bloqueada por fallos de login.
+func checkOIDCThrottle(ctx context.Context, c *fiber.Ctx) (*loginBlock, error) {
+	return checkLoginThrottle(ctx, failureScopeIP, c.IP(), currentLockoutPolicy())
+}
+
+// oidcFailed registra un callback rechazado como intento fallido de la IP, igual que un login con
+// contraseña errónea, y responde status con errMsg (o el bloqueo si con este fallo se alcanza).
+func oidcFailed(c *fiber.Ctx, status int, errMsg, detail string) error {
+	utils.LogAction(0, "oidc_login", "fallido", detail)
+	block, err := recordLoginFailure(context.Background(), failureScopeIP, c.IP(), currentLockoutPolicy())
+	if err != nil {
+		log.Printf("Error al registrar intento fallido por IP: %v", err)
+	} else if block != nil {
+		return respondLoginBlocked(c, block)
+	}
+	return c.Status(status).JSON(fiber.Map{"error": errMsg})
+}
+
+// linkOIDCIdentity busca la cuenta vinculada a (issuer, sub). En el primer login la vincula por
+// correo, solo si el IdP lo declara verificado y la cuenta es de personal.
+func linkOIDCIdentity(ctx context.Context, issuer string, claims *oidc.Claims) (*oidcUser, error) {
+	var user oidcUser
+	err := config.Conn.QueryRow(ctx,
+		`UPDATE oidc_identities i SET last_login_at = now() FROM usuarios u
+		WHERE u.id_usuario = i.id_usuario AND i.issuer = $1 AND i.subject = $2
+		RETURNING u.id_usuario, u.rol, u.correo, u.activo`,
+		issuer, claims.Subject).Scan(&user.ID, &user.Rol, &user.Correo, &user.Activo)
+	if err == nil {
+		if user.Rol == "Paciente" {
+			return nil, errOIDCPatient
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errOIDCUnknownUser
+	}
+	err = config.Conn.QueryRow(ctx,
+		"SELECT id_usuario, rol, correo, activo FROM usuarios WHERE lower(correo) = $1",
+		normalizeEmail(claims.Email)).Scan(&user.ID, &user.Rol, &user.Correo, &user.Activo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errOIDCUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Rol == "Paciente" {
+		return nil, errOIDCPatient
+	}
+	_, err = config.Conn.Exec(ctx,
+		"INSERT INTO oidc_identities (issuer, subject, id_usuario, correo, last_login_at) VALUES ($1, $2, $3, $4, now())",
+		issuer, claims.Subject, user.ID, claims.Email)
+	if isUniqueViolation(err) {
+		// Otra identidad del mismo IdP ya está vinculada a la cuenta
+		return nil, errOIDCUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	utils.LogAction(user.ID, "oidc_link", "exitoso", "Identidad "+claims.Subject+" vinculada por correo "+claims.Email)
+	return &user, nil
+}
+
+// StartOIDCLogin genera state, nonce y el reto PKCE y devuelve la URL del IdP a la que el
+// frontend debe redirigir. El IdP vuelve a OIDC_REDIRECT_URL con code y state.
+func StartOIDCLogin(c *fiber.Ctx) error {
+	if config.OIDC == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Inicio de sesión corporativo no configurado"})
+	}
+	ctx := context.Background()
+
+	block, err := checkOIDCThrottle(ctx, c)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if block != nil {
+		utils.LogAction(0, "oidc_login", "fallido", "Intento rechazado por bloqueo de la IP "+c.IP())
+		return respondLoginBlocked(c, block)
+	}
+	var pending int
+	err = config.Conn.QueryRow(ctx,
+		"SELECT count(*) FROM oidc_login_states WHERE ip = $1 AND expires_at > now()", c.IP()).Scan(&pending)
+	if err != nil {
+		log.Printf("Error al consultar estados OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al consultar estados: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if pending >= oidcMaxPendingStates {
+		utils.LogAction(0, "oidc_login", "fallido", "Demasiados inicios de sesión pendientes desde "+c.IP())
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(oidcStateTTL.Seconds())))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Demasiados intentos, espera antes de reintentar"})
+	}
+
+	state, err := oidc.NewState()
+	var nonce, verifier string
+	if err == nil {
+		nonce, err = oidc.NewState()
+	}
+	if err == nil {
+		verifier, err = oidc.NewCodeVerifier()
+	}
+	if err != nil {
+		log.Printf("Error al generar estado OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al generar estado: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+
+	authURL, err := config.OIDC.AuthCodeURL(ctx, state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		log.Printf("Error en el descubrimiento OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error en el descubrimiento del IdP: "+err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "No se pudo contactar al proveedor de identidad"})
+	}
+	expiresAt := time.Now().Add(oidcStateTTL)
+	_, err = config.Conn.Exec(ctx,
+		"INSERT INTO oidc_login_states (state_hash, code_verifier, nonce, ip, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		hashToken(state), verifier, nonce, c.IP(), expiresAt)
+	if err != nil {
+		log.Printf("Error al guardar estado OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al guardar estado: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+
+	setOIDCStateCookie(c, state, expiresAt)
+	utils.LogAction(0, "oidc_login", "exitoso", "Redirección al IdP desde "+c.IP())
+	return c.JSON(fiber.Map{"authorization_url": authURL})
+}
+
+// FinishOIDCLogin canjea el código del IdP, valida el ID token y emite nuestros tokens.
+// El segundo factor lo exige el IdP, por lo que aquí no se pide TOTP. El state debe coincidir con
+// la cookie que dejó StartOIDCLogin en el mismo navegador.
+func FinishOIDCLogin(c *fiber.Ctx) error {
+	if config.OIDC == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Inicio de sesión corporativo no configurado"})
+	}
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "oidc_login", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	if input.Code == "" || input.State == "" {
+		utils.LogAction(0, "oidc_login", "fallido", "Callback sin code o state")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code y state son obligatorios"})
+	}
+	ctx := context.Background()
+
+	block, err := checkOIDCThrottle(ctx, c)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if block != nil {
+		utils.LogAction(0, "oidc_login", "fallido", "Intento rechazado por bloqueo de la IP "+c.IP())
+		return respondLoginBlocked(c, block)
+	}
+
+	cookieState := c.Cookies(oidcStateCookie)
+	setOIDCStateCookie(c, "", time.Unix(0, 0))
+	if subtle.ConstantTimeCompare([]byte(cookieState), []byte(input.State)) != 1 {
+		return oidcFailed(c, fiber.StatusBadRequest, "Inicio de sesión expirado, vuelve a intentarlo",
+			"State no coincide con la cookie del navegador")
+	}
+
+	verifier, nonce, err := takeOIDCState(ctx, input.State)
+	if errors.Is(err, errOIDCStateMissing) {
+		return oidcFailed(c, fiber.StatusBadRequest, "Inicio de sesión expirado, vuelve a intentarlo",
+			"State inválido, usado o expirado")
+	}
+	if err != nil {
+		log.Printf("Error al consultar estado OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al consultar estado: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+
+	rawIDToken, err := config.OIDC.Exchange(ctx, input.Code, verifier)
+	if err != nil {
+		log.Printf("Error al canjear código OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al canjear código: "+err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "El proveedor de identidad rechazó el inicio de sesión"})
+	}
+	claims, err := config.OIDC.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return oidcFailed(c, fiber.StatusUnauthorized, "Credenciales inválidas", "ID token inválido: "+err.Error())
+	}
+
+	user, err := linkOIDCIdentity(ctx, config.OIDC.Issuer, claims)
+	if errors.Is(err, errOIDCUnknownUser) || errors.Is(err, errOIDCPatient) {
+		return oidcFailed(c, fiber.StatusForbidden, "No existe una cuenta de personal para esta identidad",
+			"Identidad "+claims.Subject+" ("+claims.Email+") rechazada: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error al vincular identidad OIDC: %v", err)
+		utils.LogAction(0, "oidc_login", "fallido", "Error al vincular identidad: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if !user.Activo {
+		utils.LogAction(user.ID, "oidc_login", "fallido", "Cuenta desactivada: "+user.Correo)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cuenta desactivada"})
+	}
+
+	loginSucceeded(ctx, c, user.ID)
+
+	accessToken, refreshToken, err := GenerateTokens(user.ID, user.Rol, deviceFromCtx(c))
+	if err != nil {
+		log.Printf("Error al generar tokens: %v", err)
+		utils.LogAction(user.ID, "oidc_login", "fallido", "Error al generar tokens: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar tokens"})
+	}
+
+	utils.LogAction(user.ID, "oidc_login", "exitoso", "Inicio de sesión con el IdP para "+user.Correo+" (usuario "+strconv.Itoa(user.ID)+")")
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
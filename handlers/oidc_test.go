@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/config"
+	"hospitalaria/oidc"
+)
+
+// useTestOIDC configura config.OIDC contra un IdP local que solo publica el descubrimiento.
+func useTestOIDC(t *testing.T) {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(server.Close)
+	prev := config.OIDC
+	config.OIDC = oidc.NewProvider(server.URL, "hospitalaria", "secreto", "http://localhost:3000/oidc/callback")
+	t.Cleanup(func() { config.OIDC = prev })
+}
+
+func newOIDCApp() *fiber.App {
+	app := fiber.New()
+	app.Get("/login/oidc", StartOIDCLogin)
+	app.Post("/login/oidc/callback", FinishOIDCLogin)
+	return app
+}
+
+func TestStartOIDCLoginSetsStateCookie(t *testing.T) {
+	useTestOIDC(t)
+	var storedState, storedIP interface{}
+	db := newFakeDB(t)
+	db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("SELECT count(*) FROM oidc_login_states", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{0}}}
+	})
+	db.on("INSERT INTO oidc_login_states", func(args []interface{}) fakeResult {
+		storedState, storedIP = args[0], args[3]
+		return fakeResult{affected: 1}
+	})
+
+	resp, err := newOIDCApp().Test(httptest.NewRequest("GET", "/login/oidc", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("estado %d, se esperaba 200", resp.StatusCode)
+	}
+	var out struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(out.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := u.Query().Get("state")
+
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != state || !cookie.HttpOnly || cookie.Path != "/login/oidc" {
+		t.Fatalf("cookie de state inesperada: %+v (state %q)", cookie, state)
+	}
+	if storedState != hashToken(state) || storedIP != "0.0.0.0" {
+		t.Fatalf("estado guardado = %v desde %v", storedState, storedIP)
+	}
+}
+
+func TestStartOIDCLoginLimitsPendingStatesPerIP(t *testing.T) {
+	useTestOIDC(t)
+	db := newFakeDB(t)
+	db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("SELECT count(*) FROM oidc_login_states", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{oidcMaxPendingStates}}}
+	})
+
+	resp, err := newOIDCApp().Test(httptest.NewRequest("GET", "/login/oidc", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("estado %d, se esperaba 429", resp.StatusCode)
+	}
+}
+
+func TestFinishOIDCLoginRequiresStateCookie(t *testing.T) {
+	useTestOIDC(t)
+	for _, tc := range []struct {
+		name   string
+		cookie string
+	}{
+		{"sin cookie", ""},
+		{"cookie de otro login", "state-de-la-victima"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var failures []interface{}
+			db := newFakeDB(t)
+			db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+			db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+				failures = append(failures, args[0])
+				return fakeResult{rows: [][]interface{}{{len(failures)}}}
+			})
+			// Sin manejador para oidc_login_states: el state no debe consumirse
+
+			req := httptest.NewRequest("POST", "/login/oidc/callback",
+				bytes.NewBufferString(`{"code":"codigo","state":"state-del-atacante"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tc.cookie})
+			}
+			resp, err := newOIDCApp().Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("estado %d, se esperaba 400", resp.StatusCode)
+			}
+			if len(failures) != 1 || failures[0] != failureScopeIP {
+				t.Fatalf("fallos registrados = %v, se esperaba uno por IP", failures)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package pacientes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+// Handlers de /pacientes/:id/... para el paciente y su equipo de atención.
+// middleware.RequirePatientAccess valida la relación y deja el id en Locals("id_paciente").
+
+func GetPatientExpediente(c *fiber.Ctx) error {
+    userID := c.Locals("user_id").(int)
+    idPaciente := c.Locals("id_paciente").(int)
+
+    var exp struct {
+        IDExpediente        int    `json:"id_expediente"`
+        AntecedentesMedicos string `json:"antecedentes_medicos"`
+        Alergias            string `json:"alergias"`
+        Tratamientos        string `json:"tratamientos"`
+        FechaActualizacion  string `json:"fecha_actualizacion"`
+    }
+    err := config.Conn.QueryRow(context.Background(),
+        "SELECT id_expediente, antecedentes_medicos, alergias, tratamientos, fecha_actualizacion FROM expedientes WHERE id_paciente = $1",
+        idPaciente).Scan(&exp.IDExpediente, &exp.AntecedentesMedicos, &exp.Alergias, &exp.Tratamientos, &exp.FechaActualizacion)
+    if errors.Is(err, pgx.ErrNoRows) {
+        utils.LogAction(userID, "read_expediente", "fallido", "Expediente no encontrado para paciente ID "+strconv.Itoa(idPaciente))
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Expediente no encontrado"})
+    }
+    if err != nil {
+        log.Printf("Error al obtener expediente: %v", err)
+        utils.LogAction(userID, "read_expediente", "fallido", "Error al obtener expediente: "+err.Error())
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener expediente"})
+    }
+    utils.LogAction(userID, "read_expediente", "exitoso", "Expediente leído para paciente ID "+strconv.Itoa(idPaciente))
+    return c.JSON(exp)
+}
+
+func GetPatientAppointments(c *fiber.Ctx) error {
+    userID := c.Locals("user_id").(int)
+    idPaciente := c.Locals("id_paciente").(int)
+
+    rows, err := config.Conn.Query(context.Background(),
+        "SELECT id_cita, id_medico, fecha_hora, estado, motivo FROM citas WHERE id_paciente = $1 ORDER BY fecha_hora DESC", idPaciente)
+    if err != nil {
+        log.Printf("Error al obtener citas: %v", err)
+        utils.LogAction(userID, "read_appointment", "fallido", "Error al obtener citas: "+err.Error())
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener citas"})
+    }
+    defer rows.Close()
+    type cita struct {
+        ID_cita   int    `json:"id_cita"`
+        IDMedico  int    `json:"id_medico"`
+        FechaHora string `json:"fecha_hora"`
+        Estado    string `json:"estado"`
+        Motivo    string `json:"motivo"`
+    }
+    appointments := []cita{}
+    for rows.Next() {
+        var app cita
+        if err := rows.Scan(&app.ID_cita, &app.IDMedico, &app.FechaHora, &app.Estado, &app.Motivo); err != nil {
+            utils.LogAction(userID, "read_appointment", "fallido", "Error al leer cita: "+err.Error())
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al leer citas"})
+        }
+        appointments = append(appointments, app)
+    }
+    utils.LogAction(userID, "read_appointment", "exitoso", "Citas leídas para paciente ID "+strconv.Itoa(idPaciente))
+    return c.JSON(appointments)
+}
+
+func GetPatientConsultas(c *fiber.Ctx) error {
+    userID := c.Locals("user_id").(int)
+    idPaciente := c.Locals("id_paciente").(int)
+
+    rows, err := config.Conn.Query(context.Background(),
+        "SELECT id_consulta, id_cita, id_medico, id_enfermera, fecha_hora, COALESCE(diagnostico, ''), estado FROM consultas WHERE id_paciente = $1 ORDER BY fecha_hora DESC",
+        idPaciente)
+    if err != nil {
+        log.Printf("Error al obtener consultas: %v", err)
+        utils.LogAction(userID, "read_consulta", "fallido", "Error al obtener consultas: "+err.Error())
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener consultas"})
+    }
+    defer rows.Close()
+    type consulta struct {
+        IDConsulta  int    `json:"id_consulta"`
+        IDCita      int    `json:"id_cita"`
+        IDMedico    int    `json:"id_medico"`
+        IDEnfermera int    `json:"id_enfermera"`
+        FechaHora   string `json:"fecha_hora"`
+        Diagnostico string `json:"diagnostico"`
+        Estado      string `json:"estado"`
+    }
+    consultas := []consulta{}
+    for rows.Next() {
+        var co consulta
+        if err := rows.Scan(&co.IDConsulta, &co.IDCita, &co.IDMedico, &co.IDEnfermera, &co.FechaHora, &co.Diagnostico, &co.Estado); err != nil {
+            utils.LogAction(userID, "read_consulta", "fallido", "Error al leer consulta: "+err.Error())
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al leer consultas"})
+        }
+        consultas = append(consultas, co)
+    }
+    utils.LogAction(userID, "read_consulta", "exitoso", "Consultas leídas para paciente ID "+strconv.Itoa(idPaciente))
+    return c.JSON(consultas)
+}
@@ -8,17 +8,11 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"hospitalaria/config"
 	"hospitalaria/utils"
-	"github.com/jackc/pgx/v4"
 )
 
 func CreateAppointment(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud recibida para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "create_appointment", "fallido", "Permiso denegado: Solo Pacientes pueden agendar citas")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type AppointmentInput struct {
         IDMedico       int    `json:"id_medico"`
@@ -58,21 +52,13 @@ func CreateAppointment(c *fiber.Ctx) error {
 
 func GetAppointments(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
-    role := c.Locals("role").(string)
-    var rows pgx.Rows
-    var err error
-    if role == "Paciente" {
-        var idPaciente int
-        err = config.Conn.QueryRow(context.Background(), "SELECT id_paciente FROM pacientes WHERE id_usuario = $1", userID).Scan(&idPaciente)
-        if err != nil {
-            utils.LogAction(userID, "read_appointment", "fallido", "Paciente no encontrado: "+err.Error())
-            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Paciente no encontrado"})
-        }
-        rows, err = config.Conn.Query(context.Background(), "SELECT id_cita, id_medico, fecha_hora, estado, motivo FROM citas WHERE id_paciente = $1", idPaciente)
-    } else {
-        utils.LogAction(userID, "read_appointment", "fallido", "Permiso denegado: Solo Pacientes pueden ver sus citas")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
+    var idPaciente int
+    err := config.Conn.QueryRow(context.Background(), "SELECT id_paciente FROM pacientes WHERE id_usuario = $1", userID).Scan(&idPaciente)
+    if err != nil {
+        utils.LogAction(userID, "read_appointment", "fallido", "Paciente no encontrado: "+err.Error())
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Paciente no encontrado"})
     }
+    rows, err := config.Conn.Query(context.Background(), "SELECT id_cita, id_medico, fecha_hora, estado, motivo FROM citas WHERE id_paciente = $1", idPaciente)
     if err != nil {
         log.Printf("Error al obtener citas: %v", err)
         utils.LogAction(userID, "read_appointment", "fallido", "Error al obtener citas: "+err.Error())
@@ -107,11 +93,6 @@ func GetAppointments(c *fiber.Ctx) error {
 
 func DeleteAppointment(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "delete_appointment", "fallido", "Permiso denegado: Solo Pacientes pueden cancelar citas")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type AppointmentDelete struct {
         ID_cita int `json:"id_cita"`
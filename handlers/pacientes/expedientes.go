@@ -14,11 +14,6 @@ import (
 func CreateExpediente(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud recibida para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "create_expediente", "fallido", "Permiso denegado: Solo Pacientes pueden crear expedientes")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type ExpedienteInput struct {
         AntecedentesMedicos string `json:"antecedentes_medicos"`
@@ -56,11 +51,6 @@ func CreateExpediente(c *fiber.Ctx) error {
 func GetExpedientes(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de lectura para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "read_expediente", "fallido", "Permiso denegado: Solo Pacientes pueden ver expedientes")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     var idPaciente int
     err := config.Conn.QueryRow(context.Background(), "SELECT id_paciente FROM pacientes WHERE id_usuario = $1", userID).Scan(&idPaciente)
@@ -90,11 +80,6 @@ func GetExpedientes(c *fiber.Ctx) error {
 func UpdateExpediente(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de actualización para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "update_expediente", "fallido", "Permiso denegado: Solo Pacientes pueden actualizar expedientes")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     type ExpedienteUpdate struct {
         AntecedentesMedicos string `json:"antecedentes_medicos,omitempty"`
@@ -157,11 +142,6 @@ func UpdateExpediente(c *fiber.Ctx) error {
 func DeleteExpediente(c *fiber.Ctx) error {
     userID := c.Locals("user_id").(int)
     log.Printf("Solicitud de eliminación para userID: %d", userID)
-    role := c.Locals("role").(string)
-    if role != "Paciente" {
-        utils.LogAction(userID, "delete_expediente", "fallido", "Permiso denegado: Solo Pacientes pueden eliminar expedientes")
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
-    }
 
     var idPaciente int
     err := config.Conn.QueryRow(context.Background(), "SELECT id_paciente FROM pacientes WHERE id_usuario = $1", userID).Scan(&idPaciente)
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/config"
+	"hospitalaria/passwordpolicy"
+	"hospitalaria/utils"
+)
+
+var errPasswordReused = errors.New("contraseña usada recientemente")
+
+// passwordHistorySize es el número de contraseñas recientes, contando la actual, que no pueden reutilizarse.
+func passwordHistorySize() int {
+	return utils.GetEnvInt("PASSWORD_HISTORY_SIZE", 5)
+}
+
+// storedHistorySize es cuántas contraseñas anteriores se guardan en password_history: la actual
+// está en usuarios, así que basta con passwordHistorySize - 1.
+func storedHistorySize() int {
+	if n := passwordHistorySize() - 1; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// checkPasswordHistory devuelve errPasswordReused si password coincide con la contraseña actual
+// (currentHash) o con alguna de las storedHistorySize guardadas en el historial.
+func checkPasswordHistory(ctx context.Context, userID int, currentHash, password string) error {
+	if checkPassword(currentHash, password) == nil {
+		return errPasswordReused
+	}
+	rows, err := config.Conn.Query(ctx,
+		"SELECT password_hash FROM password_history WHERE id_usuario = $1 ORDER BY created_at DESC, id_historial DESC LIMIT $2",
+		userID, storedHistorySize())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return err
+		}
+		if checkPassword(hash, password) == nil {
+			return errPasswordReused
+		}
+	}
+	return rows.Err()
+}
+
+// updatePassword guarda newHash como contraseña del usuario, mueve la anterior al historial
+// y descarta las entradas que exceden storedHistorySize.
+func updatePassword(ctx context.Context, db execer, userID int, oldHash, newHash string) error {
+	_, err := db.Exec(ctx, "UPDATE usuarios SET contraseña = $2 WHERE id_usuario = $1", userID, newHash)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, "INSERT INTO password_history (id_usuario, password_hash) VALUES ($1, $2)", userID, oldHash)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx,
+		`DELETE FROM password_history WHERE id_usuario = $1 AND id_historial NOT IN (
+			SELECT id_historial FROM password_history WHERE id_usuario = $1
+			ORDER BY created_at DESC, id_historial DESC LIMIT $2)`,
+		userID, storedHistorySize())
+	return err
+}
+
+// ChangePassword cambia la contraseña del usuario autenticado. Exige la contraseña actual y un
+// código TOTP nuevo; al terminar cierra todas las demás sesiones y conserva la actual.
+func ChangePassword(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	familyID := c.Locals("family_id").(string)
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+		TOTPCode        string `json:"totp_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "change_password", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar contraseña"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "change_password", "fallido", "Intento rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	var currentHash, secret string
+	var user passwordpolicy.UserInfo
+	err = config.Conn.QueryRow(ctx,
+		"SELECT contraseña, totp_secret, nombre, apellido, correo FROM usuarios WHERE id_usuario = $1", userID).Scan(
+		&currentHash, &secret, &user.Nombre, &user.Apellido, &user.Correo)
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+
+	if err := checkPassword(currentHash, input.CurrentPassword); err != nil {
+		return credentialFailed(c, userID, "change_password", "Contraseña actual incorrecta", "Contraseña actual incorrecta")
+	}
+	err = verifyTOTP(ctx, userID, input.TOTPCode, secret)
+	if errors.Is(err, errTOTPInvalid) || errors.Is(err, errTOTPReused) {
+		return credentialFailed(c, userID, "change_password", "Código TOTP inválido", "Código TOTP inválido o reutilizado")
+	}
+	if err != nil {
+		log.Printf("Error al verificar TOTP: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al verificar TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar contraseña"})
+	}
+
+	if violations := CheckPasswordStrength(input.NewPassword, user); len(violations) > 0 {
+		return respondWeakPassword(c, userID, "change_password", violations)
+	}
+	err = checkPasswordHistory(ctx, userID, currentHash, input.NewPassword)
+	if errors.Is(err, errPasswordReused) {
+		utils.LogAction(userID, "change_password", "fallido", "Contraseña reutilizada")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No puedes reutilizar ninguna de tus últimas " + strconv.Itoa(passwordHistorySize()) + " contraseñas",
+		})
+	}
+	if err != nil {
+		log.Printf("Error al consultar historial de contraseñas: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al consultar historial: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar contraseña"})
+	}
+
+	hash, err := hashPassword(input.NewPassword)
+	if err != nil {
+		log.Printf("Error al hashear contraseña: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al hashear contraseña: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al procesar contraseña"})
+	}
+
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar contraseña"})
+	}
+	defer tx.Rollback(ctx)
+	err = updatePassword(ctx, tx, userID, currentHash, hash)
+	if err == nil {
+		err = revokeOtherSessions(ctx, tx, userID, familyID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al cambiar contraseña: %v", err)
+		utils.LogAction(userID, "change_password", "fallido", "Error al cambiar contraseña: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cambiar contraseña"})
+	}
+
+	utils.LogAction(userID, "change_password", "exitoso", "Contraseña cambiada y demás sesiones revocadas")
+	return c.JSON(fiber.Map{"message": "Contraseña cambiada"})
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChangePasswordWrongPasswordCountsTowardLockout(t *testing.T) {
+	t.Setenv("LOGIN_MAX_FAILURES", "2")
+	hash, err := bcrypt.GenerateFromPassword([]byte("Contraseña-Actual-123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures := map[string]int{}
+	var lockedUntil interface{}
+	db := newFakeDB(t)
+	db.on("SELECT failures, last_failure_at, locked_until FROM login_failures", func(args []interface{}) fakeResult {
+		if args[0] == failureScopeAccount && lockedUntil != nil {
+			return fakeResult{rows: [][]interface{}{{0, nil, lockedUntil}}}
+		}
+		return fakeResult{}
+	})
+	db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+		failures[args[0].(string)]++
+		return fakeResult{rows: [][]interface{}{{failures[args[0].(string)]}}}
+	})
+	db.on("UPDATE login_failures SET failures = 0, locked_until", func(args []interface{}) fakeResult {
+		if args[0] == failureScopeAccount {
+			lockedUntil = args[2]
+		}
+		return fakeResult{affected: 1}
+	})
+	db.on("SELECT contraseña, totp_secret, nombre, apellido, correo FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{string(hash), testTOTPSeed, "Ana", "López", "ana@hospital.com"}}}
+	})
+
+	app := fiber.New()
+	app.Put("/password", withUser(1, "Paciente"), func(c *fiber.Ctx) error {
+		c.Locals("family_id", "familia")
+		return c.Next()
+	}, ChangePassword)
+	put := func() int {
+		req := httptest.NewRequest("PUT", "/password", bytes.NewBufferString(
+			`{"current_password":"incorrecta","new_password":"Otra-Contraseña-456","totp_code":"000000"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if status := put(); status != fiber.StatusUnauthorized {
+		t.Fatalf("primer intento: estado %d, se esperaba 401", status)
+	}
+	if status := put(); status != fiber.StatusLocked {
+		t.Fatalf("segundo intento: estado %d, se esperaba 423", status)
+	}
+	if status := put(); status != fiber.StatusLocked {
+		t.Fatalf("con la cuenta bloqueada: estado %d, se esperaba 423", status)
+	}
+	if failures[failureScopeAccount] != 2 || failures[failureScopeIP] != 2 {
+		t.Fatalf("fallos = %v; la cuenta bloqueada no debe comprobar la contraseña", failures)
+	}
+}
+
+func TestStoredHistorySizeCountsCurrentPassword(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int
+	}{{"5", 4}, {"1", 0}, {"0", 0}} {
+		t.Setenv("PASSWORD_HISTORY_SIZE", tc.env)
+		if got := storedHistorySize(); got != tc.want {
+			t.Errorf("PASSWORD_HISTORY_SIZE=%s: storedHistorySize() = %d, se esperaba %d", tc.env, got, tc.want)
+		}
+	}
+}
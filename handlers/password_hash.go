@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"hospitalaria/config"
+	"hospitalaria/passwordhash"
+	"hospitalaria/utils"
+)
+
+// hashPassword genera el hash de una contraseña nueva con el algoritmo configurado.
+func hashPassword(password string) (string, error) {
+	return config.PasswordHasher.Hash(password)
+}
+
+// checkPassword compara password con el hash guardado; devuelve passwordhash.ErrMismatch si no coincide.
+func checkPassword(hash, password string) error {
+	return passwordhash.Compare(hash, password)
+}
+
+// rehashPasswordIfNeeded actualiza el hash de una contraseña recién validada si usa un algoritmo o
+// parámetros anteriores a los configurados. Es la misma contraseña, así que no pasa por el historial.
+// Un fallo solo se registra: el login continúa con el hash anterior.
+func rehashPasswordIfNeeded(ctx context.Context, userID int, currentHash, password string) {
+	if !config.PasswordHasher.NeedsRehash(currentHash) {
+		return
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error al actualizar hash de contraseña: %v", err)
+		utils.LogAction(userID, "password_rehash", "fallido", "Error al hashear contraseña: "+err.Error())
+		return
+	}
+	// Si la contraseña cambió entre tanto, no se sobrescribe
+	_, err = config.Conn.Exec(ctx,
+		"UPDATE usuarios SET contraseña = $3 WHERE id_usuario = $1 AND contraseña = $2", userID, currentHash, hash)
+	if err != nil {
+		log.Printf("Error al actualizar hash de contraseña: %v", err)
+		utils.LogAction(userID, "password_rehash", "fallido", "Error al guardar hash: "+err.Error())
+		return
+	}
+	utils.LogAction(userID, "password_rehash", "exitoso", "Hash de contraseña actualizado a "+config.PasswordHasher.Algorithm)
+}
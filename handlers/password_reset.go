@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/passwordpolicy"
+	"hospitalaria/utils"
+)
+
+// passwordResetResendInterval evita enviar varios enlaces seguidos a la misma cuenta.
+const passwordResetResendInterval = time.Minute
+
+// newOpaqueToken genera un token aleatorio de 256 bits en base64 URL-safe.
+func newOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func passwordResetTTL() time.Duration {
+	return time.Duration(utils.GetEnvInt("PASSWORD_RESET_TTL_MINUTES", 30)) * time.Minute
+}
+
+// frontendLink construye el enlace que recibe el usuario por correo, con el token como parámetro.
+// base se toma de la variable de entorno envKey o, si no existe, de def.
+func frontendLink(envKey, def, token string) string {
+	base := os.Getenv(envKey)
+	if base == "" {
+		base = def
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// ForgotPassword envía un enlace de restablecimiento si el correo pertenece a una cuenta.
+// La respuesta es siempre la misma para no revelar qué correos están registrados.
+func ForgotPassword(c *fiber.Ctx) error {
+	var input struct {
+		Correo string `json:"correo"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "forgot_password", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	input.Correo = normalizeEmail(input.Correo)
+	response := fiber.Map{"message": "Si el correo está registrado, recibirás un enlace para restablecer tu contraseña"}
+
+	ctx := context.Background()
+	var userID int
+	err := config.Conn.QueryRow(ctx, "SELECT id_usuario FROM usuarios WHERE lower(correo) = $1", input.Correo).Scan(&userID)
+	if err != nil {
+		utils.LogAction(0, "forgot_password", "fallido", "Correo no encontrado o error en consulta: "+err.Error())
+		return c.JSON(response)
+	}
+
+	var recent bool
+	err = config.Conn.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM password_reset_tokens WHERE id_usuario = $1 AND created_at > now() - $2 * interval '1 second')",
+		userID, int(passwordResetResendInterval.Seconds())).Scan(&recent)
+	if err != nil {
+		log.Printf("Error al consultar tokens de restablecimiento: %v", err)
+		utils.LogAction(userID, "forgot_password", "fallido", "Error al consultar tokens: "+err.Error())
+		return c.JSON(response)
+	}
+	if recent {
+		utils.LogAction(userID, "forgot_password", "fallido", "Solicitud repetida antes de "+passwordResetResendInterval.String())
+		return c.JSON(response)
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("Error al generar token de restablecimiento: %v", err)
+		utils.LogAction(userID, "forgot_password", "fallido", "Error al generar token: "+err.Error())
+		return c.JSON(response)
+	}
+	ttl := passwordResetTTL()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "forgot_password", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.JSON(response)
+	}
+	defer tx.Rollback(ctx)
+	// Solo el enlace más reciente es válido
+	_, err = tx.Exec(ctx, "UPDATE password_reset_tokens SET used_at = now() WHERE id_usuario = $1 AND used_at IS NULL", userID)
+	if err == nil {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO password_reset_tokens (id_usuario, token_hash, ip, expires_at) VALUES ($1, $2, $3, $4)",
+			userID, hashToken(token), c.IP(), time.Now().Add(ttl))
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al guardar token de restablecimiento: %v", err)
+		utils.LogAction(userID, "forgot_password", "fallido", "Error al guardar token: "+err.Error())
+		return c.JSON(response)
+	}
+
+	link := frontendLink("PASSWORD_RESET_URL", "http://localhost:3000/reset-password", token)
+	sendMailAsync(userID, "forgot_password", mailer.Message{
+		To:      input.Correo,
+		Subject: "Restablecimiento de contraseña",
+		Body: "Recibimos una solicitud para restablecer tu contraseña.\n\n" +
+			"Abre el siguiente enlace en los próximos " + strconv.Itoa(int(ttl.Minutes())) + " minutos:\n" + link + "\n\n" +
+			"Si no solicitaste el cambio, ignora este mensaje; tu contraseña actual sigue siendo válida.",
+	})
+	utils.LogAction(userID, "forgot_password", "exitoso", "Enlace de restablecimiento enviado")
+	return c.JSON(response)
+}
+
+// ResetPassword cambia la contraseña con un token de restablecimiento y cierra todas las sesiones del usuario.
+func ResetPassword(c *fiber.Ctx) error {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "reset_password", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(0, "reset_password", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al restablecer contraseña"})
+	}
+	defer tx.Rollback(ctx)
+
+	var idReset, userID int
+	var currentHash string
+	var user passwordpolicy.UserInfo
+	err = tx.QueryRow(ctx,
+		`SELECT t.id_reset, t.id_usuario, u.contraseña, u.nombre, u.apellido, u.correo FROM password_reset_tokens t
+		JOIN usuarios u ON u.id_usuario = t.id_usuario
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > now() FOR UPDATE OF t`,
+		hashToken(input.Token)).Scan(&idReset, &userID, &currentHash, &user.Nombre, &user.Apellido, &user.Correo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(0, "reset_password", "fallido", "Token de restablecimiento inválido, usado o expirado")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Enlace inválido o expirado"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar token de restablecimiento: %v", err)
+		utils.LogAction(0, "reset_password", "fallido", "Error al consultar token: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al restablecer contraseña"})
+	}
+
+	if violations := CheckPasswordStrength(input.Password, user); len(violations) > 0 {
+		return respondWeakPassword(c, userID, "reset_password", violations)
+	}
+	err = checkPasswordHistory(ctx, userID, currentHash, input.Password)
+	if errors.Is(err, errPasswordReused) {
+		utils.LogAction(userID, "reset_password", "fallido", "Contraseña reutilizada")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No puedes reutilizar ninguna de tus últimas " + strconv.Itoa(passwordHistorySize()) + " contraseñas",
+		})
+	}
+	if err != nil {
+		log.Printf("Error al consultar historial de contraseñas: %v", err)
+		utils.LogAction(userID, "reset_password", "fallido", "Error al consultar historial: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al restablecer contraseña"})
+	}
+
+	hash, err := hashPassword(input.Password)
+	if err != nil {
+		log.Printf("Error al hashear contraseña: %v", err)
+		utils.LogAction(userID, "reset_password", "fallido", "Error al hashear contraseña: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al procesar contraseña"})
+	}
+	err = updatePassword(ctx, tx, userID, currentHash, hash)
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE password_reset_tokens SET used_at = now() WHERE id_reset = $1", idReset)
+	}
+	if err == nil {
+		err = revokeUserSessions(ctx, tx, userID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al restablecer contraseña: %v", err)
+		utils.LogAction(userID, "reset_password", "fallido", "Error al restablecer contraseña: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al restablecer contraseña"})
+	}
+	if err := resetLoginFailures(ctx, userID); err != nil {
+		log.Printf("Error al reiniciar intentos fallidos: %v", err)
+	}
+
+	utils.LogAction(userID, "reset_password", "exitoso", "Contraseña restablecida y sesiones revocadas")
+	return c.JSON(fiber.Map{"message": "Contraseña restablecida"})
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/mailer"
+	"hospitalaria/models"
+	"hospitalaria/utils"
+)
+
+// profileField es un campo del perfil y la columna donde se guarda.
+type profileField struct {
+	table  string
+	column string
+}
+
+var profileFields = map[string]profileField{
+	"nombre":           {"usuarios", "nombre"},
+	"apellido":         {"usuarios", "apellido"},
+	"fecha_nacimiento": {"pacientes", "fecha_nacimiento"},
+	"genero":           {"pacientes", "genero"},
+	"direccion":        {"pacientes", "direccion"},
+	"especialidad":     {"medicos", "especialidad"},
+	"numero_colegiado": {"medicos", "numero_colegiado"},
+	"certificacion":    {"enfermeras", "certificacion"},
+}
+
+// profileEditable son los campos que cada rol puede modificar en su propio perfil. La fecha de
+// nacimiento del paciente y los datos que acreditan al personal (numero_colegiado, certificacion)
+// no se cambian desde el perfil. El correo se cambia aparte, con contraseña y verificación.
+var profileEditable = map[string][]string{
+	"Paciente":      {"nombre", "apellido", "genero", "direccion"},
+	"Medico":        {"nombre", "apellido", "especialidad"},
+	"Enfermero":     {"nombre", "apellido"},
+	"Administrador": {"nombre", "apellido"},
+}
+
+// requiredProfileFields no pueden quedar vacíos.
+var requiredProfileFields = map[string]bool{"nombre": true, "apellido": true}
+
+func canEditProfileField(rol, field string) bool {
+	for _, f := range profileEditable[rol] {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// profile es la respuesta de GET y PATCH /profile: el usuario con los datos de su rol.
+type profile struct {
+	models.User
+	RecoveryCodesRemaining int      `json:"recovery_codes_remaining"`
+	CorreoPendiente        *string  `json:"correo_pendiente"`
+	CamposEditables        []string `json:"campos_editables"`
+}
+
+func loadProfile(ctx context.Context, userID int) (*profile, error) {
+	var p profile
+	err := config.Conn.QueryRow(ctx,
+		"SELECT id_usuario, nombre, apellido, correo, rol, correo_verificado, activo FROM usuarios WHERE id_usuario = $1", userID).Scan(
+		&p.Id_usuario, &p.Nombre, &p.Apellido, &p.Correo, &p.Rol, &p.CorreoVerificado, &p.Activo)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadRoleData(ctx, &p.User); err != nil {
+		return nil, err
+	}
+	if p.RecoveryCodesRemaining, err = countRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	var pendiente string
+	err = config.Conn.QueryRow(ctx,
+		`SELECT correo FROM email_verification_tokens
+		WHERE id_usuario = $1 AND tipo = $2 AND used_at IS NULL AND expires_at > now()
+		ORDER BY created_at DESC LIMIT 1`, userID, emailTokenCambio).Scan(&pendiente)
+	if err == nil {
+		p.CorreoPendiente = &pendiente
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	p.CamposEditables = append([]string{"correo"}, profileEditable[p.Rol]...)
+	return &p, nil
+}
+
+// GetUserProfile devuelve el perfil del usuario autenticado con los datos de su rol.
+func GetUserProfile(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	p, err := loadProfile(context.Background(), userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "read_user", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener perfil: %v", err)
+		utils.LogAction(userID, "read_user", "fallido", "Error al obtener perfil: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener perfil"})
+	}
+	utils.LogAction(userID, "read_user", "exitoso", "Perfil leído para usuario "+strconv.Itoa(userID))
+	return c.JSON(p)
+}
+
+// UpdateUserProfile modifica los campos enviados que el rol del usuario puede editar. Un correo
+// nuevo exige current_password y solo se aplica cuando se confirma el enlace enviado a ese correo.
+func UpdateUserProfile(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	role := c.Locals("role").(string)
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		utils.LogAction(userID, "update_profile", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+	values := map[string]string{}
+	for key, raw := range body {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			utils.LogAction(userID, "update_profile", "fallido", "Valor no textual en "+key)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "El campo " + key + " debe ser texto"})
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	currentPassword := values["current_password"]
+	delete(values, "current_password")
+	newCorreo, changeCorreo := values["correo"]
+	newCorreo = normalizeEmail(newCorreo)
+	delete(values, "correo")
+
+	var forbidden []string
+	for field, value := range values {
+		if _, ok := profileFields[field]; !ok {
+			utils.LogAction(userID, "update_profile", "fallido", "Campo desconocido: "+field)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Campo desconocido: " + field})
+		}
+		if !canEditProfileField(role, field) {
+			forbidden = append(forbidden, field)
+		}
+		if requiredProfileFields[field] && value == "" {
+			utils.LogAction(userID, "update_profile", "fallido", "Campo obligatorio vacío: "+field)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "El campo " + field + " no puede quedar vacío"})
+		}
+	}
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		utils.LogAction(userID, "update_profile", "fallido", "Campos no editables para "+role+": "+strings.Join(forbidden, ", "))
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "No puedes modificar estos campos", "campos": forbidden})
+	}
+
+	ctx := context.Background()
+	var currentCorreo, currentHash string
+	err := config.Conn.QueryRow(ctx, "SELECT correo, contraseña FROM usuarios WHERE id_usuario = $1", userID).Scan(&currentCorreo, &currentHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "update_profile", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al consultar usuario: %v", err)
+		utils.LogAction(userID, "update_profile", "fallido", "Error al consultar usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+	}
+	if changeCorreo && newCorreo == normalizeEmail(currentCorreo) {
+		changeCorreo = false
+	}
+	if changeCorreo {
+		if !validEmail(newCorreo) {
+			utils.LogAction(userID, "update_profile", "fallido", "Correo inválido: "+newCorreo)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Correo inválido"})
+		}
+		block, err := checkCredentialThrottle(ctx, c, userID)
+		if err != nil {
+			log.Printf("Error al consultar intentos fallidos: %v", err)
+			utils.LogAction(userID, "update_profile", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+		}
+		if block != nil {
+			utils.LogAction(userID, "update_profile", "fallido", "Intento rechazado por bloqueo o espera")
+			return respondLoginBlocked(c, block)
+		}
+		if checkPassword(currentHash, currentPassword) != nil {
+			return credentialFailed(c, userID, "update_profile", "Contraseña actual incorrecta", "Contraseña incorrecta al cambiar correo")
+		}
+		var taken bool
+		err = config.Conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM usuarios WHERE lower(correo) = $1)", newCorreo).Scan(&taken)
+		if err != nil {
+			log.Printf("Error al comprobar correo: %v", err)
+			utils.LogAction(userID, "update_profile", "fallido", "Error al comprobar correo: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+		}
+		if taken {
+			utils.LogAction(userID, "update_profile", "fallido", "Correo ya registrado: "+newCorreo)
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "El correo ya está registrado"})
+		}
+	}
+
+	// Una sentencia UPDATE por tabla con los campos enviados
+	sets := map[string][]string{}
+	args := map[string][]interface{}{}
+	var changed []string
+	for field, value := range values {
+		f := profileFields[field]
+		args[f.table] = append(args[f.table], value)
+		sets[f.table] = append(sets[f.table], f.column+" = $"+strconv.Itoa(len(args[f.table])+1))
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "update_profile", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+	}
+	defer tx.Rollback(ctx)
+
+	for table, set := range sets {
+		result, err := tx.Exec(ctx, "UPDATE "+table+" SET "+strings.Join(set, ", ")+" WHERE id_usuario = $1",
+			append([]interface{}{userID}, args[table]...)...)
+		if err == nil && result.RowsAffected() == 0 {
+			err = errors.New("sin fila en " + table)
+		}
+		if err != nil {
+			log.Printf("Error al actualizar perfil: %v", err)
+			utils.LogAction(userID, "update_profile", "fallido", "Error al actualizar "+table+": "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+		}
+	}
+	var token string
+	if changeCorreo {
+		token, err = issueEmailChange(ctx, tx, userID, newCorreo)
+		if err != nil {
+			log.Printf("Error al generar verificación de correo: %v", err)
+			utils.LogAction(userID, "update_profile", "fallido", "Error al generar verificación de correo: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Printf("Error al confirmar perfil: %v", err)
+		utils.LogAction(userID, "update_profile", "fallido", "Error al confirmar perfil: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al actualizar perfil"})
+	}
+
+	if changeCorreo {
+		link := frontendLink("EMAIL_VERIFICATION_URL", "http://localhost:3000/verificar-correo", token)
+		sendMailAsync(userID, "update_profile", mailer.Message{
+			To:      newCorreo,
+			Subject: "Confirma tu nuevo correo",
+			Body: "Para usar esta dirección en tu cuenta abre el siguiente enlace:\n" + link + "\n\n" +
+				"El enlace caduca en 48 horas. Si no pediste el cambio, ignora este mensaje.",
+		})
+		sendMailAsync(userID, "update_profile", mailer.Message{
+			To:      currentCorreo,
+			Subject: "Solicitud de cambio de correo",
+			Body: "Se solicitó cambiar el correo de tu cuenta a " + newCorreo + ". El cambio se aplicará cuando se confirme desde esa dirección.\n\n" +
+				"Si no fuiste tú, cambia tu contraseña de inmediato.",
+		})
+		utils.LogAction(userID, "update_profile", "exitoso", "Cambio de correo solicitado a "+newCorreo)
+	}
+	if len(changed) > 0 {
+		utils.LogAction(userID, "update_profile", "exitoso", "Campos actualizados: "+strings.Join(changed, ", "))
+	}
+
+	p, err := loadProfile(ctx, userID)
+	if err != nil {
+		log.Printf("Error al obtener perfil: %v", err)
+		utils.LogAction(userID, "read_user", "fallido", "Error al obtener perfil: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener perfil"})
+	}
+	return c.JSON(p)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func patchProfile(t *testing.T, body string) int {
+	t.Helper()
+	app := fiber.New()
+	app.Patch("/profile", withUser(7, "Paciente"), UpdateUserProfile)
+	req := httptest.NewRequest("PATCH", "/profile", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestUpdateProfileUserLookupErrors(t *testing.T) {
+	db := newFakeDB(t)
+	db.on("SELECT correo, contraseña FROM usuarios", func([]interface{}) fakeResult { return fakeResult{} })
+	if status := patchProfile(t, `{"nombre":"Ana"}`); status != fiber.StatusNotFound {
+		t.Fatalf("usuario inexistente: estado %d, se esperaba 404", status)
+	}
+
+	db = newFakeDB(t)
+	db.on("SELECT correo, contraseña FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{err: errors.New("conexión perdida")}
+	})
+	if status := patchProfile(t, `{"nombre":"Ana"}`); status != fiber.StatusInternalServerError {
+		t.Fatalf("error de base de datos: estado %d, se esperaba 500", status)
+	}
+}
+
+func TestUpdateProfileRejectsInvalidEmail(t *testing.T) {
+	db := newFakeDB(t) // solo se lee el usuario; no se comprueba la contraseña ni el correo
+	db.on("SELECT correo, contraseña FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{"ana@hospital.com", "hash"}}}
+	})
+	for _, correo := range []string{"ana@otro.com\r\nBcc: x@evil.com", "Ana <ana@otro.com>", "ana@"} {
+		body := string(mustJSON(t, map[string]string{"correo": correo, "current_password": "x"}))
+		if status := patchProfile(t, body); status != fiber.StatusBadRequest {
+			t.Errorf("correo %q: estado %d, se esperaba 400", correo, status)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	recoveryCodeCount    = 10
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+var errRecoveryCodeInvalid = errors.New("código de recuperación inválido")
+
+// newRecoveryCode genera un código con formato xxxxx-xxxxx sin caracteres ambiguos.
+func newRecoveryCode() (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// normalizeRecoveryCode ignora mayúsculas, espacios y guiones al comparar.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// replaceRecoveryCodes invalida los códigos anteriores de userID y guarda un juego nuevo.
+// Devuelve los códigos en claro; es la única vez que pueden mostrarse.
+func replaceRecoveryCodes(ctx context.Context, db execer, userID int) ([]string, error) {
+	if _, err := db.Exec(ctx, "DELETE FROM recovery_codes WHERE id_usuario = $1", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec(ctx,
+			"INSERT INTO recovery_codes (id_usuario, code_hash) VALUES ($1, $2)", userID, string(hash)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// consumeRecoveryCode marca como usado el código de userID que coincide con code.
+func consumeRecoveryCode(ctx context.Context, userID int, code string) error {
+	normalized := normalizeRecoveryCode(code)
+	rows, err := config.Conn.Query(ctx,
+		"SELECT id_recovery_code, code_hash FROM recovery_codes WHERE id_usuario = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+	matchID := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(normalized)) == nil {
+			matchID = id
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if matchID == 0 {
+		return errRecoveryCodeInvalid
+	}
+
+	result, err := config.Conn.Exec(ctx,
+		"UPDATE recovery_codes SET used_at = now() WHERE id_recovery_code = $1 AND used_at IS NULL", matchID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func countRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	var remaining int
+	err := config.Conn.QueryRow(ctx,
+		"SELECT count(*) FROM recovery_codes WHERE id_usuario = $1 AND used_at IS NULL", userID).Scan(&remaining)
+	return remaining, err
+}
+
+// RegenerateRecoveryCodes sustituye los códigos de recuperación del usuario; exige un código TOTP vigente.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		TOTPCode string `json:"totp_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Intento rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	var secret string
+	err = config.Conn.QueryRow(ctx, "SELECT totp_secret FROM usuarios WHERE id_usuario = $1", userID).Scan(&secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+	err = verifyTOTP(ctx, userID, input.TOTPCode, secret)
+	if factorRejected(err) {
+		return credentialFailed(c, userID, "regenerate_recovery_codes", "Código TOTP inválido", "Código TOTP rechazado: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error al verificar TOTP: %v", err)
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Error al verificar TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+	defer tx.Rollback(ctx)
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		log.Printf("Error al generar códigos de recuperación: %v", err)
+		utils.LogAction(userID, "regenerate_recovery_codes", "fallido", "Error al generar códigos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar códigos de recuperación"})
+	}
+
+	utils.LogAction(userID, "regenerate_recovery_codes", "exitoso", "Códigos de recuperación regenerados")
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
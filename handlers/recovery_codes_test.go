@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRegenerateRecoveryCodesRejectedTOTP(t *testing.T) {
+	useTestTOTP(t)
+	for _, tc := range []struct {
+		name      string
+		stepErr   error
+		want      int
+		wantFails int
+	}{
+		{name: "código incorrecto", want: fiber.StatusUnauthorized, wantFails: 1},
+		{name: "error de base de datos", stepErr: errors.New("conexión perdida"), want: fiber.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failures := map[string]int{}
+			db := newFakeDB(t)
+			db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+			db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+				failures[args[0].(string)]++
+				return fakeResult{rows: [][]interface{}{{failures[args[0].(string)]}}}
+			})
+			db.on("SELECT totp_secret FROM usuarios", func([]interface{}) fakeResult {
+				return fakeResult{rows: [][]interface{}{{testTOTPSeed}}}
+			})
+			db.on("SET totp_last_step", func([]interface{}) fakeResult { return fakeResult{err: tc.stepErr} })
+
+			code := "000000"
+			if tc.stepErr != nil {
+				code = totpCodeNow(t)
+			}
+			app := fiber.New()
+			app.Post("/mfa/recovery-codes", withUser(1, "Paciente"), RegenerateRecoveryCodes)
+			req := httptest.NewRequest("POST", "/mfa/recovery-codes", bytes.NewBufferString(`{"totp_code":"`+code+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Fatalf("estado %d, se esperaba %d", resp.StatusCode, tc.want)
+			}
+			if failures[failureScopeAccount] != tc.wantFails || failures[failureScopeIP] != tc.wantFails {
+				t.Fatalf("fallos = %v, se esperaba %d por ámbito", failures, tc.wantFails)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	accessTokenTTL  = 10 * time.Minute
+	refreshTokenTTL = 24 * time.Hour
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("refresh token inválido o expirado")
+	errRefreshTokenReused  = errors.New("refresh token reutilizado")
+)
+
+// DeviceInfo identifica el dispositivo desde el que se abrió una sesión.
+type DeviceInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func deviceFromCtx(c *fiber.Ctx) DeviceInfo {
+	return DeviceInfo{UserAgent: c.Get(fiber.HeaderUserAgent), IP: c.IP()}
+}
+
+// hashToken devuelve el SHA-256 en hexadecimal; en la base de datos nunca se guarda el token en claro.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signAccessToken firma un access token con jti propio, ligado a la familia de sesión familyID.
+func signAccessToken(userID int, role, familyID string) (string, string, time.Time, error) {
+	jti := uuid.NewString()
+	expiresAt := time.Now().Add(accessTokenTTL)
+	signed, err := config.JWTKeys.Sign(jwt.MapClaims{
+		"user_id":    userID,
+		"role":       role,
+		"jti":        jti,
+		"family_id":  familyID,
+		"token_type": "access",
+		"exp":        expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return signed, jti, expiresAt, nil
+}
+
+func signRefreshToken(userID int, role, jti, familyID string, expiresAt time.Time) (string, error) {
+	return config.JWTKeys.Sign(jwt.MapClaims{
+		"user_id":    userID,
+		"role":       role,
+		"jti":        jti,
+		"family_id":  familyID,
+		"token_type": "refresh",
+		"exp":        expiresAt.Unix(),
+	})
+}
+
+const insertRefreshTokenSQL = `INSERT INTO refresh_tokens (jti, id_usuario, family_id, token_hash, user_agent, ip, expires_at, access_jti, access_expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+// execer es satisfecho tanto por config.Conn como por una pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// revokeFamily revoca los refresh tokens de una sesión y añade sus access tokens vigentes a la lista de revocación.
+func revokeFamily(ctx context.Context, db execer, familyID string) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO revoked_tokens (jti, id_usuario, expires_at)
+		SELECT access_jti, id_usuario, access_expires_at FROM refresh_tokens
+		WHERE family_id = $1 AND access_jti IS NOT NULL AND access_expires_at > now()
+		ON CONFLICT (jti) DO NOTHING`, familyID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+// revokeUserSessions revoca todas las sesiones del usuario, igual que revokeFamily pero para cada familia.
+func revokeUserSessions(ctx context.Context, db execer, userID int) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO revoked_tokens (jti, id_usuario, expires_at)
+		SELECT access_jti, id_usuario, access_expires_at FROM refresh_tokens
+		WHERE id_usuario = $1 AND access_jti IS NOT NULL AND access_expires_at > now()
+		ON CONFLICT (jti) DO NOTHING`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE id_usuario = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// revokeOtherSessions revoca todas las sesiones del usuario excepto la familia keepFamilyID.
+func revokeOtherSessions(ctx context.Context, db execer, userID int, keepFamilyID string) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO revoked_tokens (jti, id_usuario, expires_at)
+		SELECT access_jti, id_usuario, access_expires_at FROM refresh_tokens
+		WHERE id_usuario = $1 AND family_id <> $2 AND access_jti IS NOT NULL AND access_expires_at > now()
+		ON CONFLICT (jti) DO NOTHING`, userID, keepFamilyID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE id_usuario = $1 AND family_id <> $2 AND revoked_at IS NULL",
+		userID, keepFamilyID)
+	return err
+}
+
+// revokeAccessToken añade un único access token a la lista de revocación.
+func revokeAccessToken(ctx context.Context, db execer, jti string, userID int, expiresAt time.Time) error {
+	_, err := db.Exec(ctx,
+		"INSERT INTO revoked_tokens (jti, id_usuario, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING",
+		jti, userID, expiresAt)
+	return err
+}
+
+// issueRefreshToken firma un refresh token nuevo dentro de familyID y devuelve el token y su jti.
+// El llamador es responsable de persistirlo con insertRefreshTokenSQL.
+func issueRefreshToken(userID int, role, familyID string) (string, string, time.Time, error) {
+	jti := uuid.NewString()
+	expiresAt := time.Now().Add(refreshTokenTTL)
+	refresh, err := signRefreshToken(userID, role, jti, familyID, expiresAt)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return refresh, jti, expiresAt, nil
+}
+
+// rotateRefreshToken consume el refresh token identificado por jti y emite un par nuevo en la misma familia.
+// Si el token ya había sido usado, se revoca la familia completa y se devuelve errRefreshTokenReused.
+func rotateRefreshToken(ctx context.Context, jti, rawToken string, device DeviceInfo) (int, string, string, error) {
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		userID    int
+		familyID  string
+		tokenHash string
+		expiresAt time.Time
+		usedAt    *time.Time
+		revokedAt *time.Time
+	)
+	err = tx.QueryRow(ctx,
+		"SELECT id_usuario, family_id, token_hash, expires_at, used_at, revoked_at FROM refresh_tokens WHERE jti = $1 FOR UPDATE",
+		jti).Scan(&userID, &familyID, &tokenHash, &expiresAt, &usedAt, &revokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", "", errRefreshTokenInvalid
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hashToken(rawToken))) != 1 {
+		return userID, "", "", errRefreshTokenInvalid
+	}
+	if usedAt != nil {
+		if err := revokeFamily(ctx, tx, familyID); err != nil {
+			return userID, "", "", err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return userID, "", "", err
+		}
+		utils.LogAction(userID, "refresh_token", "fallido", "Reutilización detectada, familia revocada: "+familyID)
+		return userID, "", "", errRefreshTokenReused
+	}
+	if revokedAt != nil || time.Now().After(expiresAt) {
+		return userID, "", "", errRefreshTokenInvalid
+	}
+
+	var role string
+	if err := tx.QueryRow(ctx, "SELECT rol FROM usuarios WHERE id_usuario = $1", userID).Scan(&role); err != nil {
+		return userID, "", "", err
+	}
+
+	access, accessJTI, accessExpiresAt, err := signAccessToken(userID, role, familyID)
+	if err != nil {
+		return userID, "", "", err
+	}
+	refresh, newJTI, newExpiresAt, err := issueRefreshToken(userID, role, familyID)
+	if err != nil {
+		return userID, "", "", err
+	}
+	if _, err := tx.Exec(ctx, insertRefreshTokenSQL,
+		newJTI, userID, familyID, hashToken(refresh), device.UserAgent, device.IP, newExpiresAt, accessJTI, accessExpiresAt); err != nil {
+		return userID, "", "", err
+	}
+	if _, err := tx.Exec(ctx,
+		"UPDATE refresh_tokens SET used_at = now(), replaced_by = $2 WHERE jti = $1", jti, newJTI); err != nil {
+		return userID, "", "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return userID, "", "", err
+	}
+	return userID, access, refresh, nil
+}
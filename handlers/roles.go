@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/models"
+)
+
+// roleTables asocia cada rol clínico con la tabla de sus datos propios. Administrador no tiene tabla.
+var roleTables = map[string]string{
+	"Paciente":  "pacientes",
+	"Medico":    "medicos",
+	"Enfermero": "enfermeras",
+}
+
+// insertRoleData guarda los datos propios de rol tomados de data, si el rol tiene tabla.
+func insertRoleData(ctx context.Context, db execer, userID int, rol string, data models.User) error {
+	var err error
+	switch rol {
+	case "Paciente":
+		_, err = db.Exec(ctx,
+			"INSERT INTO pacientes (id_usuario, fecha_nacimiento, genero, direccion) VALUES ($1, $2, $3, $4)",
+			userID, data.FechaNacimiento, data.Genero, data.Direccion)
+	case "Medico":
+		_, err = db.Exec(ctx,
+			"INSERT INTO medicos (id_usuario, especialidad, numero_colegiado) VALUES ($1, $2, $3)",
+			userID, data.Especialidad, data.NumeroColegiado)
+	case "Enfermero":
+		_, err = db.Exec(ctx,
+			"INSERT INTO enfermeras (id_usuario, certificacion) VALUES ($1, $2)",
+			userID, data.Certificacion)
+	}
+	return err
+}
+
+// hasRoleData indica si userID ya tiene fila en la tabla de rol.
+func hasRoleData(ctx context.Context, tx pgx.Tx, userID int, rol string) (bool, error) {
+	table, ok := roleTables[rol]
+	if !ok {
+		return true, nil
+	}
+	var exists bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id_usuario = $1)", userID).Scan(&exists)
+	return exists, err
+}
+
+// loadRoleData completa en user los datos propios de su rol. Un usuario sin fila de rol no es un error.
+func loadRoleData(ctx context.Context, user *models.User) error {
+	var err error
+	switch user.Rol {
+	case "Paciente":
+		err = config.Conn.QueryRow(ctx,
+			"SELECT COALESCE(fecha_nacimiento::text, ''), COALESCE(genero, ''), COALESCE(direccion, '') FROM pacientes WHERE id_usuario = $1",
+			user.Id_usuario).Scan(&user.FechaNacimiento, &user.Genero, &user.Direccion)
+	case "Medico":
+		err = config.Conn.QueryRow(ctx,
+			"SELECT COALESCE(especialidad, ''), COALESCE(numero_colegiado, '') FROM medicos WHERE id_usuario = $1",
+			user.Id_usuario).Scan(&user.Especialidad, &user.NumeroColegiado)
+	case "Enfermero":
+		err = config.Conn.QueryRow(ctx,
+			"SELECT COALESCE(certificacion, '') FROM enfermeras WHERE id_usuario = $1",
+			user.Id_usuario).Scan(&user.Certificacion)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
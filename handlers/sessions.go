@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+// Session es una familia de refresh tokens vista como un dispositivo con sesión abierta.
+// user_agent e ip son los de la última renovación; last_used_at es la hora de esa renovación.
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// GetSessions lista las sesiones activas del usuario, la más reciente primero.
+func GetSessions(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	familyID := c.Locals("family_id").(string)
+
+	// Una familia está activa si conserva un refresh token sin usar, sin revocar y vigente
+	rows, err := config.Conn.Query(context.Background(),
+		`SELECT family_id,
+			(array_agg(user_agent ORDER BY created_at DESC))[1],
+			(array_agg(ip ORDER BY created_at DESC))[1],
+			min(created_at), max(created_at), max(expires_at)
+		FROM refresh_tokens WHERE id_usuario = $1
+		GROUP BY family_id
+		HAVING bool_or(used_at IS NULL AND revoked_at IS NULL AND expires_at > now())
+		ORDER BY max(created_at) DESC`, userID)
+	if err != nil {
+		log.Printf("Error al listar sesiones: %v", err)
+		utils.LogAction(userID, "list_sessions", "fallido", "Error al listar sesiones: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar sesiones"})
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			log.Printf("Error al leer sesión: %v", err)
+			utils.LogAction(userID, "list_sessions", "fallido", "Error al leer sesión: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar sesiones"})
+		}
+		s.Current = s.ID == familyID
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error al listar sesiones: %v", err)
+		utils.LogAction(userID, "list_sessions", "fallido", "Error al listar sesiones: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al listar sesiones"})
+	}
+
+	utils.LogAction(userID, "list_sessions", "exitoso", "Sesiones listadas")
+	return c.JSON(fiber.Map{"sessions": sessions})
+}
+
+// DeleteSession cierra una sesión del usuario, por ejemplo la de otro dispositivo.
+// Se revocan sus refresh tokens y el último access token emitido en ella.
+func DeleteSession(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+	sessionID := c.Params("id")
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "delete_session", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+	defer tx.Rollback(ctx)
+
+	// Solo se pueden cerrar sesiones propias y aún abiertas
+	var exists bool
+	err = tx.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND id_usuario = $2 AND revoked_at IS NULL AND expires_at > now())",
+		sessionID, userID).Scan(&exists)
+	if err != nil {
+		log.Printf("Error al consultar sesión: %v", err)
+		utils.LogAction(userID, "delete_session", "fallido", "Error al consultar sesión: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+	if !exists {
+		utils.LogAction(userID, "delete_session", "fallido", "Sesión no encontrada: "+sessionID)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Sesión no encontrada"})
+	}
+	if err := revokeFamily(ctx, tx, sessionID); err != nil {
+		utils.LogAction(userID, "delete_session", "fallido", "Error al revocar sesión: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.LogAction(userID, "delete_session", "fallido", "Error al confirmar cierre de sesión: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al cerrar sesión"})
+	}
+
+	utils.LogAction(userID, "delete_session", "exitoso", "Sesión cerrada: "+sessionID)
+	return c.JSON(fiber.Map{"message": "Sesión cerrada"})
+}
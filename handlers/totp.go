@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/skip2/go-qrcode"
+	"hospitalaria/config"
+	"hospitalaria/secretbox"
+)
+
+const totpIssuer = "MyHospitalApp"
+
+// totpSecretAAD liga un secreto cifrado a su uso y a su usuario: un valor cifrado para otra columna
+// o copiado de la fila de otro usuario no se descifra.
+func totpSecretAAD(userID int) string {
+	return "usuarios.totp_secret:" + strconv.Itoa(userID)
+}
+
+// legacyTOTPSecretAAD es el AAD de los secretos cifrados en formato v1, antes de ligarlos al usuario.
+const legacyTOTPSecretAAD = "usuarios.totp_secret"
+
+var (
+	errTOTPInvalid = errors.New("código TOTP inválido")
+	errTOTPReused  = errors.New("código TOTP ya utilizado")
+)
+
+// totpConfig es compartida por el alta (CreateUser) y la verificación (Login).
+// Period y Digits quedan grabados en la app del usuario al escanear el QR, por lo que
+// solo deben cambiarse antes de que existan usuarios inscritos; Skew puede ajustarse libremente.
+type totpConfig struct {
+	period uint
+	digits otp.Digits
+	skew   uint
+}
+
+// currentTOTPConfig toma los parámetros validados al arrancar (config.InitTOTP).
+func currentTOTPConfig() totpConfig {
+	return totpConfig{
+		period: config.TOTP.Period,
+		digits: config.TOTP.Digits,
+		skew:   config.TOTP.Skew,
+	}
+}
+
+func (cfg totpConfig) generateOpts(accountName string) totp.GenerateOpts {
+	return totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: accountName,
+		Period:      cfg.period,
+		Digits:      cfg.digits,
+		Algorithm:   otp.AlgorithmSHA1,
+	}
+}
+
+// matchTOTPStep busca, dentro de la ventana de deriva, el paso temporal cuyo código coincide con code.
+func (cfg totpConfig) matchTOTPStep(code, secret string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: cfg.period, Skew: 0, Digits: cfg.digits, Algorithm: otp.AlgorithmSHA1}
+	for i := -int(cfg.skew); i <= int(cfg.skew); i++ {
+		t := now.Add(time.Duration(i) * time.Duration(cfg.period) * time.Second)
+		if ok, err := totp.ValidateCustom(code, secret, t, opts); err == nil && ok {
+			return t.Unix() / int64(cfg.period), true
+		}
+	}
+	return 0, false
+}
+
+// sealTOTPSecret cifra un secreto TOTP para guardarlo en usuarios (totp_secret o totp_pending_secret).
+func sealTOTPSecret(userID int, secret string) (string, error) {
+	return config.TOTPSecrets.Encrypt(secret, totpSecretAAD(userID))
+}
+
+// openTOTPSecret descifra un secreto TOTP guardado. Los secretos aún en texto plano se devuelven tal cual.
+func openTOTPSecret(userID int, stored string) (string, error) {
+	aad := totpSecretAAD(userID)
+	if secretbox.IsLegacy(stored) {
+		// ReencryptTOTPSecrets lo vuelve a cifrar ligado al usuario al arrancar
+		aad = legacyTOTPSecretAAD
+	}
+	return config.TOTPSecrets.Decrypt(stored, aad)
+}
+
+// verifyTOTP valida code para userID con su secreto guardado (cifrado) y registra su paso temporal como consumido.
+// Devuelve errTOTPReused si el paso ya fue aceptado antes (o uno posterior).
+func verifyTOTP(ctx context.Context, userID int, code, storedSecret string) error {
+	secret, err := openTOTPSecret(userID, storedSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := currentTOTPConfig().matchTOTPStep(code, secret, time.Now())
+	if !ok {
+		return errTOTPInvalid
+	}
+	result, err := config.Conn.Exec(ctx,
+		"UPDATE usuarios SET totp_last_step = $2 WHERE id_usuario = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)",
+		userID, step)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errTOTPReused
+	}
+	return nil
+}
+
+// verifyCurrentFactor exige un código TOTP del secreto actual o, en su defecto, un código de recuperación.
+// Se usa antes de operaciones sensibles sobre los propios factores del usuario.
+func verifyCurrentFactor(ctx context.Context, userID int, storedSecret, totpCode, recoveryCode string) error {
+	if recoveryCode != "" {
+		return consumeRecoveryCode(ctx, userID, recoveryCode)
+	}
+	return verifyTOTP(ctx, userID, totpCode, storedSecret)
+}
+
+// factorRejected distingue un código erróneo o reutilizado, que cuenta como intento fallido,
+// de un error interno al comprobarlo.
+func factorRejected(err error) bool {
+	return errors.Is(err, errTOTPInvalid) || errors.Is(err, errTOTPReused) || errors.Is(err, errRecoveryCodeInvalid)
+}
+
+// totpQRDataURI codifica la URL otpauth:// de key como PNG en data URI, lista para mostrarse en el frontend.
+func totpQRDataURI(key *otp.Key) (string, error) {
+	qrCode, err := qrcode.Encode(key.URL(), qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode), nil
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/pquerna/otp/totp"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	totpEnrollmentTTL   = 15 * time.Minute
+	enrollmentTokenTTL  = 10 * time.Minute
+	enrollmentTokenType = "totp_enrollment"
+)
+
+var errTOTPEnrollmentMissing = errors.New("no hay una inscripción TOTP pendiente")
+
+// startTOTPEnrollment genera un secreto pendiente para userID y devuelve su QR.
+// El secreto actual sigue vigente hasta que confirmTOTPEnrollment valide un código del nuevo.
+func startTOTPEnrollment(ctx context.Context, userID int) (string, error) {
+	var correo string
+	if err := config.Conn.QueryRow(ctx, "SELECT correo FROM usuarios WHERE id_usuario = $1", userID).Scan(&correo); err != nil {
+		return "", err
+	}
+	key, err := totp.Generate(currentTOTPConfig().generateOpts(correo))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealTOTPSecret(userID, key.Secret())
+	if err != nil {
+		return "", err
+	}
+	_, err = config.Conn.Exec(ctx,
+		"UPDATE usuarios SET totp_pending_secret = $2, totp_pending_at = now() WHERE id_usuario = $1",
+		userID, sealed)
+	if err != nil {
+		return "", err
+	}
+	return totpQRDataURI(key)
+}
+
+// confirmTOTPEnrollment sustituye el secreto actual por el pendiente si code es válido para este último.
+func confirmTOTPEnrollment(ctx context.Context, userID int, code string) error {
+	var (
+		pending   *string
+		pendingAt *time.Time
+	)
+	err := config.Conn.QueryRow(ctx,
+		"SELECT totp_pending_secret, totp_pending_at FROM usuarios WHERE id_usuario = $1", userID).Scan(&pending, &pendingAt)
+	if err != nil {
+		return err
+	}
+	if pending == nil || pendingAt == nil || time.Since(*pendingAt) > totpEnrollmentTTL {
+		return errTOTPEnrollmentMissing
+	}
+	secret, err := openTOTPSecret(userID, *pending)
+	if err != nil {
+		return err
+	}
+	step, ok := currentTOTPConfig().matchTOTPStep(code, secret, time.Now())
+	if !ok {
+		return errTOTPInvalid
+	}
+	result, err := config.Conn.Exec(ctx,
+		`UPDATE usuarios SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_pending_at = NULL,
+			totp_reset_required = false, totp_last_step = $2
+		WHERE id_usuario = $1 AND totp_pending_secret = $3`,
+		userID, step, *pending)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return errTOTPEnrollmentMissing
+	}
+	return nil
+}
+
+// signEnrollmentToken emite el token de corta duración con el que un usuario con reinicio TOTP
+// forzado completa su inscripción tras validar la contraseña. JWTProtected no lo acepta.
+func signEnrollmentToken(userID int) (string, error) {
+	return config.JWTKeys.Sign(jwt.MapClaims{
+		"user_id":    userID,
+		"jti":        uuid.NewString(),
+		"token_type": enrollmentTokenType,
+		"exp":        time.Now().Add(enrollmentTokenTTL).Unix(),
+	})
+}
+
+func respondTOTPEnrollmentError(c *fiber.Ctx, userID int, action string, err error) error {
+	switch {
+	case errors.Is(err, errTOTPEnrollmentMissing):
+		utils.LogAction(userID, action, "fallido", "Sin inscripción TOTP pendiente o expirada")
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "No hay una inscripción TOTP pendiente"})
+	case errors.Is(err, errTOTPInvalid):
+		utils.LogAction(userID, action, "fallido", "Código TOTP inválido para el secreto pendiente")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Código TOTP inválido"})
+	default:
+		log.Printf("Error al confirmar inscripción TOTP: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al confirmar inscripción TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+}
+
+// StartTOTPEnrollment inicia la rotación del secreto TOTP. Requiere un código del secreto actual
+// o un código de recuperación, salvo que un administrador haya forzado el reinicio.
+func StartTOTPEnrollment(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "Intento rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	var secret string
+	var resetRequired bool
+	err = config.Conn.QueryRow(ctx,
+		"SELECT totp_secret, totp_reset_required FROM usuarios WHERE id_usuario = $1", userID).Scan(&secret, &resetRequired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "Usuario no encontrado")
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "Error al obtener usuario: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+	if !resetRequired {
+		err = verifyCurrentFactor(ctx, userID, secret, input.TOTPCode, input.RecoveryCode)
+		if factorRejected(err) {
+			return credentialFailed(c, userID, "start_totp_enrollment", "Segundo factor inválido", "Segundo factor rechazado: "+err.Error())
+		}
+		if err != nil {
+			log.Printf("Error al verificar segundo factor: %v", err)
+			utils.LogAction(userID, "start_totp_enrollment", "fallido", "Error al verificar segundo factor: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+		}
+	}
+
+	qr, err := startTOTPEnrollment(ctx, userID)
+	if err != nil {
+		log.Printf("Error al iniciar inscripción TOTP: %v", err)
+		utils.LogAction(userID, "start_totp_enrollment", "fallido", "Error al iniciar inscripción TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+	utils.LogAction(userID, "start_totp_enrollment", "exitoso", "Inscripción TOTP iniciada")
+	return c.JSON(fiber.Map{
+		"totp_qr":    qr,
+		"expires_in": int(totpEnrollmentTTL.Seconds()),
+	})
+}
+
+// ConfirmTOTPEnrollment activa el secreto pendiente tras validar un código generado con él.
+func ConfirmTOTPEnrollment(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		TOTPCode string `json:"totp_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "confirm_totp_enrollment", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	if err := confirmTOTPEnrollment(context.Background(), userID, input.TOTPCode); err != nil {
+		return respondTOTPEnrollmentError(c, userID, "confirm_totp_enrollment", err)
+	}
+	utils.LogAction(userID, "confirm_totp_enrollment", "exitoso", "Secreto TOTP reemplazado")
+	return c.JSON(fiber.Map{"message": "TOTP actualizado"})
+}
+
+// CompleteTOTPEnrollment termina el login de un usuario con reinicio TOTP forzado:
+// confirma el secreto nuevo con el enrollment_token devuelto por /login y emite los tokens de sesión.
+func CompleteTOTPEnrollment(c *fiber.Ctx) error {
+	var input struct {
+		EnrollmentToken string `json:"enrollment_token"`
+		TOTPCode        string `json:"totp_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "complete_totp_enrollment", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	token, err := jwt.Parse(input.EnrollmentToken, config.JWTKeys.Keyfunc)
+	if err != nil || !token.Valid {
+		utils.LogAction(0, "complete_totp_enrollment", "fallido", "Token de inscripción inválido")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de inscripción inválido"})
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	tokenType, _ := claims["token_type"].(string)
+	userIDFloat, hasUser := claims["user_id"].(float64)
+	jti, _ := claims["jti"].(string)
+	expFloat, hasExp := claims["exp"].(float64)
+	if !ok || tokenType != enrollmentTokenType || !hasUser || jti == "" || !hasExp {
+		utils.LogAction(0, "complete_totp_enrollment", "fallido", "Token de inscripción inválido")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de inscripción inválido"})
+	}
+	userID := int(userIDFloat)
+
+	ctx := context.Background()
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Intento rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	// El token es de un solo uso y solo vale mientras el reinicio siga pendiente y la cuenta activa
+	var role string
+	var used, resetRequired, activo bool
+	err = config.Conn.QueryRow(ctx,
+		`SELECT rol, totp_reset_required, activo, EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $2)
+		FROM usuarios WHERE id_usuario = $1`, userID, jti).Scan(&role, &resetRequired, &activo, &used)
+	if err != nil {
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Usuario no encontrado: "+err.Error())
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if used || !resetRequired {
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Token de inscripción ya utilizado o sin reinicio pendiente")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token de inscripción inválido"})
+	}
+	if !activo {
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Cuenta desactivada")
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cuenta desactivada"})
+	}
+
+	err = confirmTOTPEnrollment(ctx, userID, input.TOTPCode)
+	if errors.Is(err, errTOTPInvalid) {
+		return credentialFailed(c, userID, "complete_totp_enrollment", "Código TOTP inválido", "Código TOTP inválido para el secreto pendiente")
+	}
+	if err != nil {
+		return respondTOTPEnrollmentError(c, userID, "complete_totp_enrollment", err)
+	}
+	_, err = config.Conn.Exec(ctx,
+		"INSERT INTO revoked_tokens (jti, id_usuario, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING",
+		jti, userID, time.Unix(int64(expFloat), 0))
+	if err != nil {
+		log.Printf("Error al consumir token de inscripción: %v", err)
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Error al consumir token de inscripción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al inscribir TOTP"})
+	}
+	accessToken, refreshToken, err := GenerateTokens(userID, role, deviceFromCtx(c))
+	if err != nil {
+		log.Printf("Error al generar tokens: %v", err)
+		utils.LogAction(userID, "complete_totp_enrollment", "fallido", "Error al generar tokens: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar tokens"})
+	}
+	loginSucceeded(ctx, c, userID)
+
+	utils.LogAction(userID, "complete_totp_enrollment", "exitoso", "Inscripción TOTP completada e inicio de sesión exitoso")
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// ResetTOTP obliga a un usuario a inscribir un secreto TOTP nuevo en su próximo login y cierra sus sesiones.
+func ResetTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	targetID, err := c.ParamsInt("id")
+	if err != nil || targetID <= 0 {
+		utils.LogAction(userID, "reset_totp", "fallido", "ID de usuario inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de usuario inválido"})
+	}
+
+	ctx := context.Background()
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		log.Printf("Error al iniciar transacción: %v", err)
+		utils.LogAction(userID, "reset_totp", "fallido", "Error al iniciar transacción: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al reiniciar TOTP"})
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx,
+		"UPDATE usuarios SET totp_reset_required = true, totp_pending_secret = NULL, totp_pending_at = NULL WHERE id_usuario = $1",
+		targetID)
+	if err != nil {
+		utils.LogAction(userID, "reset_totp", "fallido", "Error al reiniciar TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al reiniciar TOTP"})
+	}
+	if result.RowsAffected() == 0 {
+		utils.LogAction(userID, "reset_totp", "fallido", "Usuario no encontrado: ID "+strconv.Itoa(targetID))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err := revokeUserSessions(ctx, tx, targetID); err != nil {
+		utils.LogAction(userID, "reset_totp", "fallido", "Error al revocar sesiones: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al reiniciar TOTP"})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		utils.LogAction(userID, "reset_totp", "fallido", "Error al confirmar reinicio TOTP: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al reiniciar TOTP"})
+	}
+
+	utils.LogAction(userID, "reset_totp", "exitoso", "Reinicio TOTP forzado para usuario ID "+strconv.Itoa(targetID))
+	return c.JSON(fiber.Map{"message": "El usuario deberá inscribir un nuevo TOTP en su próximo inicio de sesión"})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStartTOTPEnrollmentRejectedFactor(t *testing.T) {
+	useTestTOTP(t)
+	for _, tc := range []struct {
+		name      string
+		userErr   error
+		body      string
+		want      int
+		wantFails int
+	}{
+		{name: "código TOTP incorrecto", body: `{"totp_code":"000000"}`, want: fiber.StatusUnauthorized, wantFails: 1},
+		{name: "código de recuperación incorrecto", body: `{"recovery_code":"aaaaa-aaaaa"}`, want: fiber.StatusUnauthorized, wantFails: 1},
+		{name: "error de base de datos", userErr: errors.New("conexión perdida"), body: `{"totp_code":"000000"}`, want: fiber.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			failures := map[string]int{}
+			db := newFakeDB(t)
+			db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+			db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+				failures[args[0].(string)]++
+				return fakeResult{rows: [][]interface{}{{failures[args[0].(string)]}}}
+			})
+			db.on("SELECT totp_secret, totp_reset_required FROM usuarios", func([]interface{}) fakeResult {
+				if tc.userErr != nil {
+					return fakeResult{err: tc.userErr}
+				}
+				return fakeResult{rows: [][]interface{}{{testTOTPSeed, false}}}
+			})
+			db.on("FROM recovery_codes", func([]interface{}) fakeResult { return fakeResult{} })
+
+			app := fiber.New()
+			app.Post("/mfa/totp/enroll", withUser(1, "Paciente"), StartTOTPEnrollment)
+			req := httptest.NewRequest("POST", "/mfa/totp/enroll", bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Fatalf("estado %d, se esperaba %d", resp.StatusCode, tc.want)
+			}
+			if failures[failureScopeAccount] != tc.wantFails || failures[failureScopeIP] != tc.wantFails {
+				t.Fatalf("fallos = %v, se esperaba %d por ámbito", failures, tc.wantFails)
+			}
+		})
+	}
+}
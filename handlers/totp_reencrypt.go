@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+
+	"hospitalaria/config"
+	"hospitalaria/secretbox"
+)
+
+const totpReencryptBatch = 100
+
+// ReencryptTOTPSecrets cifra con la clave activa los secretos TOTP (actuales y pendientes) que siguen en
+// texto plano, en formato v1 (sin ligar al usuario) o cifrados con una clave anterior. Trabaja por lotes
+// y con SKIP LOCKED para que varias instancias puedan ejecutarlo a la vez al arrancar. Devuelve el
+// número de usuarios actualizados.
+func ReencryptTOTPSecrets(ctx context.Context) (int, error) {
+	activePrefix := secretbox.EncryptedWith(config.TOTPSecrets.Active())
+	total := 0
+	for {
+		n, err := reencryptTOTPBatch(ctx, activePrefix)
+		total += n
+		if err != nil || n < totpReencryptBatch {
+			return total, err
+		}
+	}
+}
+
+func reencryptTOTPBatch(ctx context.Context, activePrefix string) (int, error) {
+	tx, err := config.Conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT id_usuario, COALESCE(totp_secret, ''), totp_pending_secret FROM usuarios
+		WHERE (COALESCE(totp_secret, '') <> '' AND NOT starts_with(totp_secret, $1))
+			OR (totp_pending_secret IS NOT NULL AND NOT starts_with(totp_pending_secret, $1))
+		ORDER BY id_usuario LIMIT $2 FOR UPDATE SKIP LOCKED`,
+		activePrefix, totpReencryptBatch)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		userID  int
+		secret  string
+		pending *string
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.userID, &r.secret, &r.pending); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range batch {
+		secret, err := reencryptTOTPValue(r.userID, r.secret)
+		if err != nil {
+			return 0, err
+		}
+		pending := r.pending
+		if pending != nil {
+			value, err := reencryptTOTPValue(r.userID, *pending)
+			if err != nil {
+				return 0, err
+			}
+			pending = &value
+		}
+		_, err = tx.Exec(ctx,
+			"UPDATE usuarios SET totp_secret = CASE WHEN $2 = '' THEN totp_secret ELSE $2 END, totp_pending_secret = $3 WHERE id_usuario = $1",
+			r.userID, secret, pending)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit(ctx)
+}
+
+func reencryptTOTPValue(userID int, stored string) (string, error) {
+	if stored == "" || !config.TOTPSecrets.NeedsReencrypt(stored) {
+		return stored, nil
+	}
+	secret, err := openTOTPSecret(userID, stored)
+	if err != nil {
+		return "", err
+	}
+	return sealTOTPSecret(userID, secret)
+}
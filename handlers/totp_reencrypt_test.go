@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"hospitalaria/config"
+	"hospitalaria/secretbox"
+)
+
+type totpRow struct {
+	secret  string
+	pending *string
+}
+
+// fakeTOTPTable atiende las consultas de ReencryptTOTPSecrets sobre una tabla de usuarios en memoria.
+func fakeTOTPTable(t *testing.T, users map[int]*totpRow) {
+	db := newFakeDB(t)
+	db.on("SELECT id_usuario, COALESCE(totp_secret, ''), totp_pending_secret FROM usuarios", func(args []interface{}) fakeResult {
+		prefix, limit := args[0].(string), args[1].(int)
+		var ids []int
+		for id, u := range users {
+			stale := u.secret != "" && !strings.HasPrefix(u.secret, prefix)
+			if stale || (u.pending != nil && !strings.HasPrefix(*u.pending, prefix)) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+		if len(ids) > limit {
+			ids = ids[:limit]
+		}
+		var rows [][]interface{}
+		for _, id := range ids {
+			rows = append(rows, []interface{}{id, users[id].secret, users[id].pending})
+		}
+		return fakeResult{rows: rows}
+	})
+	db.on("UPDATE usuarios SET totp_secret = CASE", func(args []interface{}) fakeResult {
+		u := users[args[0].(int)]
+		if secret := args[1].(string); secret != "" {
+			u.secret = secret
+		}
+		u.pending = args[2].(*string)
+		return fakeResult{affected: 1}
+	})
+}
+
+func useTOTPKeyring(t *testing.T, keys map[string][]byte, active string) {
+	t.Helper()
+	keyring, err := secretbox.NewKeyring(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := config.TOTPSecrets
+	config.TOTPSecrets = keyring
+	t.Cleanup(func() { config.TOTPSecrets = prev })
+}
+
+func TestReencryptTOTPSecretsAfterRotation(t *testing.T) {
+	oldKey, newKey := make([]byte, 32), make([]byte, 32)
+	newKey[0] = 1
+
+	// Datos cifrados antes de la rotación: texto plano, v1 (AAD común) y v2 con la clave antigua
+	useTOTPKeyring(t, map[string][]byte{"old": oldKey}, "old")
+	sealedV1, err := config.TOTPSecrets.Encrypt(testTOTPSeed, legacyTOTPSecretAAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := "enc:v1:" + strings.TrimPrefix(sealedV1, "enc:v2:")
+	oldSecret, err := sealTOTPSecret(3, testTOTPSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPending, err := sealTOTPSecret(3, "PENDIENTE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[int]*totpRow{
+		1: {secret: testTOTPSeed},
+		2: {secret: legacy},
+		3: {secret: oldSecret, pending: &oldPending},
+		4: {secret: ""},
+	}
+
+	useTOTPKeyring(t, map[string][]byte{"old": oldKey, "new": newKey}, "new")
+	fakeTOTPTable(t, users)
+	n, err := ReencryptTOTPSecrets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("usuarios actualizados = %d, se esperaban 3", n)
+	}
+
+	for id := 1; id <= 3; id++ {
+		u := users[id]
+		if !strings.HasPrefix(u.secret, "enc:v2:new:") {
+			t.Errorf("usuario %d: totp_secret = %q, se esperaba cifrado con la clave nueva", id, u.secret)
+			continue
+		}
+		if secret, err := openTOTPSecret(id, u.secret); err != nil || secret != testTOTPSeed {
+			t.Errorf("usuario %d: openTOTPSecret = %q, %v", id, secret, err)
+		}
+	}
+	if users[3].pending == nil || !strings.HasPrefix(*users[3].pending, "enc:v2:new:") {
+		t.Fatalf("totp_pending_secret no se volvió a cifrar: %v", users[3].pending)
+	}
+	if pending, err := openTOTPSecret(3, *users[3].pending); err != nil || pending != "PENDIENTE" {
+		t.Errorf("openTOTPSecret(pendiente) = %q, %v", pending, err)
+	}
+	if users[4].secret != "" || users[4].pending != nil {
+		t.Errorf("un usuario sin TOTP no debe cambiar: %+v", users[4])
+	}
+
+	n, err = ReencryptTOTPSecrets(context.Background())
+	if err != nil || n != 0 {
+		t.Fatalf("segunda pasada: %d, %v; no debería quedar nada por cifrar", n, err)
+	}
+}
+
+func TestTOTPSecretBoundToUser(t *testing.T) {
+	useTestTOTP(t)
+	sealed, err := sealTOTPSecret(1, testTOTPSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openTOTPSecret(2, sealed); err == nil {
+		t.Fatal("el secreto de otro usuario no debería descifrarse")
+	}
+
+	// Copiado a la fila de otro usuario y cifrado con una clave retirada: la migración falla en lugar de
+	// ligarlo al usuario equivocado.
+	useTOTPKeyring(t, map[string][]byte{"test": make([]byte, 32), "new": append([]byte{1}, make([]byte, 31)...)}, "new")
+	if _, err := reencryptTOTPValue(2, sealed); err == nil {
+		t.Fatal("reencryptTOTPValue aceptó un secreto copiado de otro usuario")
+	}
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"hospitalaria/config"
+	"hospitalaria/utils"
+)
+
+const (
+	webauthnCeremonyTTL      = 5 * time.Minute
+	webauthnCeremonyRegistro = "registro"
+	webauthnCeremonyLogin    = "login"
+)
+
+var errWebAuthnCeremonyMissing = errors.New("ceremonia WebAuthn inexistente o expirada")
+
+// webauthnUser adapta un registro de usuarios a la interfaz webauthn.User.
+type webauthnUser struct {
+	id          int
+	correo      string
+	displayName string
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.id)) }
+func (u *webauthnUser) WebAuthnName() string                       { return u.correo }
+func (u *webauthnUser) WebAuthnDisplayName() string                { return u.displayName }
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func loadWebAuthnUser(ctx context.Context, userID int) (*webauthnUser, error) {
+	user := &webauthnUser{id: userID}
+	var nombre, apellido string
+	err := config.Conn.QueryRow(ctx,
+		"SELECT correo, nombre, apellido FROM usuarios WHERE id_usuario = $1", userID).Scan(&user.correo, &nombre, &apellido)
+	if err != nil {
+		return nil, err
+	}
+	user.displayName = nombre + " " + apellido
+
+	rows, err := config.Conn.Query(ctx, "SELECT credential FROM webauthn_credentials WHERE id_usuario = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var credential webauthn.Credential
+		if err := json.Unmarshal(raw, &credential); err != nil {
+			return nil, err
+		}
+		user.credentials = append(user.credentials, credential)
+	}
+	return user, rows.Err()
+}
+
+// saveWebAuthnCeremony guarda los datos de sesión y devuelve el identificador que el cliente debe reenviar.
+// De paso elimina las ceremonias expiradas: las abandonadas nunca se consumen.
+func saveWebAuthnCeremony(ctx context.Context, userID int, ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	if _, err := config.Conn.Exec(ctx, "DELETE FROM webauthn_ceremonies WHERE expires_at < now()"); err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	_, err = config.Conn.Exec(ctx,
+		"INSERT INTO webauthn_ceremonies (id_ceremonia, id_usuario, ceremony, session_data, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		id, userID, ceremony, data, time.Now().Add(webauthnCeremonyTTL))
+	return id, err
+}
+
+// takeWebAuthnCeremony consume una ceremonia vigente; cada desafío solo puede usarse una vez.
+func takeWebAuthnCeremony(ctx context.Context, id, ceremony string) (int, webauthn.SessionData, error) {
+	var userID int
+	var data []byte
+	var session webauthn.SessionData
+	err := config.Conn.QueryRow(ctx,
+		"DELETE FROM webauthn_ceremonies WHERE id_ceremonia = $1 AND ceremony = $2 AND expires_at > now() RETURNING id_usuario, session_data",
+		id, ceremony).Scan(&userID, &data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, session, errWebAuthnCeremonyMissing
+	}
+	if err != nil {
+		return 0, session, err
+	}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return 0, session, err
+	}
+	return userID, session, nil
+}
+
+// requireCurrentFactor comprueba el código TOTP o de recuperación de userID con el mismo bloqueo
+// por intentos que el login. Si devuelve false ya respondió a la petición y el handler debe
+// devolver el error recibido.
+func requireCurrentFactor(ctx context.Context, c *fiber.Ctx, userID int, action, errMsg, totpCode, recoveryCode string) (bool, error) {
+	block, err := checkCredentialThrottle(ctx, c, userID)
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al consultar intentos fallidos: "+err.Error())
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": errMsg})
+	}
+	if block != nil {
+		utils.LogAction(userID, action, "fallido", "Intento rechazado por bloqueo o espera")
+		return false, respondLoginBlocked(c, block)
+	}
+
+	var secret string
+	err = config.Conn.QueryRow(ctx, "SELECT totp_secret FROM usuarios WHERE id_usuario = $1", userID).Scan(&secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.LogAction(userID, action, "fallido", "Usuario no encontrado")
+		return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if err != nil {
+		log.Printf("Error al obtener usuario: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al obtener usuario: "+err.Error())
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": errMsg})
+	}
+	err = verifyCurrentFactor(ctx, userID, secret, totpCode, recoveryCode)
+	if factorRejected(err) {
+		return false, credentialFailed(c, userID, action, "Segundo factor inválido", "Segundo factor rechazado: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error al verificar segundo factor: %v", err)
+		utils.LogAction(userID, action, "fallido", "Error al verificar segundo factor: "+err.Error())
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": errMsg})
+	}
+	return true, nil
+}
+
+// BeginWebAuthnRegistration devuelve las opciones de creación de credencial para navigator.credentials.create().
+// Requiere un código TOTP o de recuperación, igual que cualquier cambio en los factores del usuario.
+func BeginWebAuthnRegistration(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	if ok, err := requireCurrentFactor(ctx, c, userID, "webauthn_register", "Error al iniciar registro de llave",
+		input.TOTPCode, input.RecoveryCode); !ok {
+		return err
+	}
+
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error al cargar usuario WebAuthn: %v", err)
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al cargar credenciales: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar registro de llave"})
+	}
+	creation, session, err := config.WebAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()))
+	if err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al iniciar registro: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar registro de llave"})
+	}
+	ceremonyID, err := saveWebAuthnCeremony(ctx, userID, webauthnCeremonyRegistro, session)
+	if err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al guardar ceremonia: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar registro de llave"})
+	}
+
+	utils.LogAction(userID, "webauthn_register", "exitoso", "Registro de llave iniciado")
+	return c.JSON(fiber.Map{"ceremony_id": ceremonyID, "options": creation})
+}
+
+// FinishWebAuthnRegistration valida la respuesta del autenticador y guarda la credencial.
+func FinishWebAuthnRegistration(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	var input struct {
+		CeremonyID string          `json:"ceremony_id"`
+		Nombre     string          `json:"nombre"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	ownerID, session, err := takeWebAuthnCeremony(ctx, input.CeremonyID, webauthnCeremonyRegistro)
+	if err != nil || ownerID != userID {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Ceremonia de registro inválida o expirada")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Registro de llave inválido o expirado"})
+	}
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error al cargar usuario WebAuthn: %v", err)
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al cargar credenciales: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al registrar llave"})
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(input.Credential)
+	if err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Respuesta de registro inválida: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Respuesta del autenticador inválida"})
+	}
+	credential, err := config.WebAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Credencial rechazada: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Respuesta del autenticador inválida"})
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al serializar credencial: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al registrar llave"})
+	}
+	var idCredencial int
+	err = config.Conn.QueryRow(ctx,
+		"INSERT INTO webauthn_credentials (id_usuario, credential_id, credential, nombre) VALUES ($1, $2, $3, $4) RETURNING id_credencial",
+		userID, credential.ID, data, input.Nombre).Scan(&idCredencial)
+	if err != nil {
+		log.Printf("Error al guardar credencial WebAuthn: %v", err)
+		utils.LogAction(userID, "webauthn_register", "fallido", "Error al guardar credencial: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al registrar llave"})
+	}
+
+	utils.LogAction(userID, "webauthn_register", "exitoso", "Llave registrada: ID "+strconv.Itoa(idCredencial))
+	return c.JSON(fiber.Map{"message": "Llave de seguridad registrada", "id_credencial": idCredencial})
+}
+
+// GetWebAuthnCredentials lista las llaves registradas por el usuario, sin material criptográfico.
+func GetWebAuthnCredentials(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	rows, err := config.Conn.Query(context.Background(),
+		"SELECT id_credencial, nombre, created_at, last_used_at FROM webauthn_credentials WHERE id_usuario = $1 ORDER BY created_at",
+		userID)
+	if err != nil {
+		log.Printf("Error al obtener llaves: %v", err)
+		utils.LogAction(userID, "read_webauthn", "fallido", "Error al obtener llaves: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener llaves"})
+	}
+	defer rows.Close()
+	type credentialInfo struct {
+		IDCredencial int        `json:"id_credencial"`
+		Nombre       string     `json:"nombre"`
+		CreatedAt    time.Time  `json:"created_at"`
+		LastUsedAt   *time.Time `json:"last_used_at"`
+	}
+	credentials := []credentialInfo{}
+	for rows.Next() {
+		var info credentialInfo
+		if err := rows.Scan(&info.IDCredencial, &info.Nombre, &info.CreatedAt, &info.LastUsedAt); err != nil {
+			utils.LogAction(userID, "read_webauthn", "fallido", "Error al leer llave: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al obtener llaves"})
+		}
+		credentials = append(credentials, info)
+	}
+	utils.LogAction(userID, "read_webauthn", "exitoso", "Llaves leídas para usuario "+strconv.Itoa(userID))
+	return c.JSON(credentials)
+}
+
+// DeleteWebAuthnCredential elimina una llave del usuario. Como el registro, requiere un código TOTP o de recuperación.
+func DeleteWebAuthnCredential(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int)
+
+	idCredencial, err := c.ParamsInt("id")
+	if err != nil {
+		utils.LogAction(userID, "delete_webauthn", "fallido", "ID de llave inválido: "+c.Params("id"))
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de llave inválido"})
+	}
+	var input struct {
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(userID, "delete_webauthn", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	if ok, err := requireCurrentFactor(ctx, c, userID, "delete_webauthn", "Error al eliminar llave",
+		input.TOTPCode, input.RecoveryCode); !ok {
+		return err
+	}
+	result, err := config.Conn.Exec(ctx,
+		"DELETE FROM webauthn_credentials WHERE id_credencial = $1 AND id_usuario = $2", idCredencial, userID)
+	if err != nil {
+		log.Printf("Error al eliminar llave: %v", err)
+		utils.LogAction(userID, "delete_webauthn", "fallido", "Error al eliminar llave: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al eliminar llave"})
+	}
+	if result.RowsAffected() == 0 {
+		utils.LogAction(userID, "delete_webauthn", "fallido", "Llave no encontrada: ID "+strconv.Itoa(idCredencial))
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Llave no encontrada"})
+	}
+	utils.LogAction(userID, "delete_webauthn", "exitoso", "Llave eliminada: ID "+strconv.Itoa(idCredencial))
+	return c.JSON(fiber.Map{"message": "Llave eliminada"})
+}
+
+// beginWebAuthnLogin es el segundo paso de Login cuando el usuario elige second_factor = "webauthn":
+// la contraseña ya fue validada y se devuelve el desafío para navigator.credentials.get().
+func beginWebAuthnLogin(c *fiber.Ctx, userID int, correo string) error {
+	ctx := context.Background()
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error al cargar usuario WebAuthn: %v", err)
+		utils.LogAction(userID, "login", "fallido", "Error al cargar llaves: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if len(user.credentials) == 0 {
+		utils.LogAction(userID, "login", "fallido", "Sin llaves de seguridad registradas para "+correo)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No hay llaves de seguridad registradas"})
+	}
+	assertion, session, err := config.WebAuthn.BeginLogin(user)
+	if err != nil {
+		utils.LogAction(userID, "login", "fallido", "Error al iniciar WebAuthn: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	ceremonyID, err := saveWebAuthnCeremony(ctx, userID, webauthnCeremonyLogin, session)
+	if err != nil {
+		utils.LogAction(userID, "login", "fallido", "Error al guardar ceremonia WebAuthn: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+
+	utils.LogAction(userID, "login", "exitoso", "Desafío WebAuthn emitido para "+correo)
+	return c.JSON(fiber.Map{
+		"webauthn_required": true,
+		"ceremony_id":       ceremonyID,
+		"options":           assertion,
+	})
+}
+
+// FinishWebAuthnLogin valida la aserción del autenticador y emite los tokens de sesión.
+func FinishWebAuthnLogin(c *fiber.Ctx) error {
+	var input struct {
+		CeremonyID string          `json:"ceremony_id"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		utils.LogAction(0, "login", "fallido", "JSON inválido: "+err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "JSON inválido"})
+	}
+
+	ctx := context.Background()
+	userID, session, err := takeWebAuthnCeremony(ctx, input.CeremonyID, webauthnCeremonyLogin)
+	if errors.Is(err, errWebAuthnCeremonyMissing) {
+		utils.LogAction(0, "login", "fallido", "Ceremonia WebAuthn inválida o expirada")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Desafío inválido o expirado"})
+	}
+	if err != nil {
+		log.Printf("Error al leer ceremonia WebAuthn: %v", err)
+		utils.LogAction(0, "login", "fallido", "Error al leer ceremonia WebAuthn: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+
+	block, err := checkLoginThrottle(ctx, failureScopeAccount, strconv.Itoa(userID), currentLockoutPolicy())
+	if err != nil {
+		log.Printf("Error al consultar intentos fallidos: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	if block != nil {
+		utils.LogAction(userID, "login", "fallido", "Intento WebAuthn rechazado por bloqueo o espera")
+		return respondLoginBlocked(c, block)
+	}
+
+	user, err := loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error al cargar usuario WebAuthn: %v", err)
+		utils.LogAction(userID, "login", "fallido", "Error al cargar llaves: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al iniciar sesión"})
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(input.Credential)
+	if err != nil {
+		return loginFailed(c, userID, "Llave de seguridad inválida", "Respuesta WebAuthn inválida: "+err.Error())
+	}
+	credential, err := config.WebAuthn.ValidateLogin(user, session, parsed)
+	if err != nil {
+		return loginFailed(c, userID, "Llave de seguridad inválida", "Aserción WebAuthn rechazada: "+err.Error())
+	}
+	// Un contador de firmas que no avanza indica que el autenticador pudo ser clonado
+	if credential.Authenticator.CloneWarning {
+		return loginFailed(c, userID, "Llave de seguridad inválida", "Posible llave clonada (contador de firmas no avanza)")
+	}
+
+	data, err := json.Marshal(credential)
+	if err == nil {
+		_, err = config.Conn.Exec(ctx,
+			"UPDATE webauthn_credentials SET credential = $2, last_used_at = now() WHERE credential_id = $1",
+			credential.ID, data)
+	}
+	if err != nil {
+		log.Printf("Error al actualizar credencial WebAuthn: %v", err)
+	}
+
+	// La cuenta pudo desactivarse entre el desafío y la respuesta del autenticador
+	var role string
+	var activo bool
+	if err := config.Conn.QueryRow(ctx, "SELECT rol, activo FROM usuarios WHERE id_usuario = $1", userID).Scan(&role, &activo); err != nil {
+		utils.LogAction(userID, "login", "fallido", "Usuario no encontrado: "+err.Error())
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Usuario no encontrado"})
+	}
+	if !activo {
+		utils.LogAction(userID, "login", "fallido", "Cuenta desactivada durante el login con llave de seguridad")
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Cuenta desactivada"})
+	}
+	accessToken, refreshToken, err := GenerateTokens(userID, role, deviceFromCtx(c))
+	if err != nil {
+		log.Printf("Error al generar tokens: %v", err)
+		utils.LogAction(userID, "login", "fallido", "Error al generar tokens: "+err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al generar tokens"})
+	}
+	loginSucceeded(ctx, c, userID)
+
+	utils.LogAction(userID, "login", "exitoso", "Inicio de sesión exitoso con llave de seguridad")
+	return c.JSON(fiber.Map{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
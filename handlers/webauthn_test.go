@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/config"
+)
+
+const (
+	testRPID     = "localhost"
+	testOrigin   = "http://localhost:3000"
+	testTOTPSeed = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+)
+
+var b64url = base64.RawURLEncoding
+
+// softAuthenticator es un autenticador WebAuthn en memoria: una clave P-256 con atestación "none".
+type softAuthenticator struct {
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{credentialID: id, key: key}
+}
+
+func (a *softAuthenticator) cosePublicKey(t *testing.T) []byte {
+	t.Helper()
+	// kty EC2 (2), alg ES256 (-7), crv P-256 (1)
+	data, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  -7,
+		-1: 1,
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authenticatorData arma rpIdHash | flags | signCount y, en el registro, los datos de la credencial.
+func (a *softAuthenticator) authenticatorData(t *testing.T, attested bool) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpHash[:]...)
+	flags := byte(0x01 | 0x04) // UP | UV
+	if attested {
+		flags |= 0x40 // AT
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.cosePublicKey(t)...)
+	}
+	return data
+}
+
+func clientDataJSON(t *testing.T, ceremonyType, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      testOrigin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create responde a navigator.credentials.create() para challenge.
+func (a *softAuthenticator) create(t *testing.T, challenge string) json.RawMessage {
+	t.Helper()
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustJSON(t, map[string]interface{}{
+		"id":    b64url.EncodeToString(a.credentialID),
+		"rawId": b64url.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url.EncodeToString(clientDataJSON(t, "webauthn.create", challenge)),
+			"attestationObject": b64url.EncodeToString(attestation),
+		},
+	})
+}
+
+// get responde a navigator.credentials.get() para challenge, firmando authenticatorData || SHA-256(clientDataJSON).
+func (a *softAuthenticator) get(t *testing.T, challenge string, userHandle []byte) json.RawMessage {
+	t.Helper()
+	authData := a.authenticatorData(t, false)
+	clientData := clientDataJSON(t, "webauthn.get", challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustJSON(t, map[string]interface{}{
+		"id":    b64url.EncodeToString(a.credentialID),
+		"rawId": b64url.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64url.EncodeToString(clientData),
+			"authenticatorData": b64url.EncodeToString(authData),
+			"signature":         b64url.EncodeToString(signature),
+			"userHandle":        b64url.EncodeToString(userHandle),
+		},
+	})
+}
+
+func mustJSON(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type storedCeremony struct {
+	userID   int
+	ceremony string
+	data     []byte
+}
+
+// webauthnFixture simula las tablas que tocan los handlers de WebAuthn para el usuario 1.
+type webauthnFixture struct {
+	app         *fiber.App
+	activo      bool
+	ceremonies  map[string]storedCeremony
+	credentials map[string][]byte
+	failures    map[string]int
+	purges      int
+	sessions    int
+	ipCredits   int
+}
+
+func newWebAuthnFixture(t *testing.T) *webauthnFixture {
+	t.Helper()
+	rp, err := webauthn.New(&webauthn.Config{RPID: testRPID, RPDisplayName: "Hospitalaria", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := config.WebAuthn
+	config.WebAuthn = rp
+	t.Cleanup(func() { config.WebAuthn = prev })
+	useTestTOTP(t)
+	useTestJWTKeys(t)
+
+	f := &webauthnFixture{activo: true, ceremonies: map[string]storedCeremony{}, credentials: map[string][]byte{}, failures: map[string]int{}}
+	db := newFakeDB(t)
+	db.on("SELECT totp_secret FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{testTOTPSeed}}}
+	})
+	db.on("SET totp_last_step", func([]interface{}) fakeResult { return fakeResult{affected: 1} })
+	db.on("SELECT correo, nombre, apellido FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{"ana@hospital.com", "Ana", "López"}}}
+	})
+	db.on("SELECT credential FROM webauthn_credentials", func([]interface{}) fakeResult {
+		var rows [][]interface{}
+		for _, data := range f.credentials {
+			rows = append(rows, []interface{}{data})
+		}
+		return fakeResult{rows: rows}
+	})
+	db.on("DELETE FROM webauthn_ceremonies WHERE expires_at < now()", func([]interface{}) fakeResult {
+		f.purges++
+		return fakeResult{}
+	})
+	db.on("INSERT INTO webauthn_ceremonies", func(args []interface{}) fakeResult {
+		f.ceremonies[args[0].(string)] = storedCeremony{userID: args[1].(int), ceremony: args[2].(string), data: args[3].([]byte)}
+		return fakeResult{affected: 1}
+	})
+	db.on("DELETE FROM webauthn_ceremonies WHERE id_ceremonia", func(args []interface{}) fakeResult {
+		stored, ok := f.ceremonies[args[0].(string)]
+		if !ok || stored.ceremony != args[1].(string) {
+			return fakeResult{}
+		}
+		delete(f.ceremonies, args[0].(string))
+		return fakeResult{rows: [][]interface{}{{stored.userID, stored.data}}}
+	})
+	db.on("INSERT INTO webauthn_credentials", func(args []interface{}) fakeResult {
+		f.credentials[string(args[1].([]byte))] = args[2].([]byte)
+		return fakeResult{rows: [][]interface{}{{len(f.credentials)}}}
+	})
+	db.on("DELETE FROM webauthn_credentials", func(args []interface{}) fakeResult {
+		for id := range f.credentials {
+			delete(f.credentials, id)
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{}
+	})
+	db.on("FROM recovery_codes", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("UPDATE webauthn_credentials SET credential", func(args []interface{}) fakeResult {
+		f.credentials[string(args[0].([]byte))] = args[1].([]byte)
+		return fakeResult{affected: 1}
+	})
+	db.on("FROM login_failures WHERE scope", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("INSERT INTO login_failures", func(args []interface{}) fakeResult {
+		scope := args[0].(string)
+		f.failures[scope]++
+		return fakeResult{rows: [][]interface{}{{f.failures[scope]}}}
+	})
+	db.on("DELETE FROM login_failures", func([]interface{}) fakeResult { return fakeResult{} })
+	db.on("SET failures = greatest(failures - 1, 0)", func(args []interface{}) fakeResult {
+		if args[0] == failureScopeIP {
+			f.ipCredits++
+		}
+		return fakeResult{affected: 1}
+	})
+	db.on("SELECT rol, activo FROM usuarios", func([]interface{}) fakeResult {
+		return fakeResult{rows: [][]interface{}{{"Paciente", f.activo}}}
+	})
+	db.on("INSERT INTO refresh_tokens", func([]interface{}) fakeResult {
+		f.sessions++
+		return fakeResult{affected: 1}
+	})
+
+	f.app = fiber.New()
+	f.app.Post("/mfa/webauthn/register/begin", withUser(1, "Paciente"), BeginWebAuthnRegistration)
+	f.app.Post("/mfa/webauthn/register/finish", withUser(1, "Paciente"), FinishWebAuthnRegistration)
+	f.app.Delete("/mfa/webauthn/credentials/:id", withUser(1, "Paciente"), DeleteWebAuthnCredential)
+	// En producción Login llama a beginWebAuthnLogin tras validar la contraseña
+	f.app.Post("/login", func(c *fiber.Ctx) error { return beginWebAuthnLogin(c, 1, "ana@hospital.com") })
+	f.app.Post("/login/webauthn", FinishWebAuthnLogin)
+	return f
+}
+
+func (f *webauthnFixture) post(t *testing.T, path string, body interface{}) (int, map[string]json.RawMessage) {
+	t.Helper()
+	return f.do(t, "POST", path, body)
+}
+
+func (f *webauthnFixture) do(t *testing.T, method, path string, body interface{}) (int, map[string]json.RawMessage) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(mustJSON(t, body)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	out := map[string]json.RawMessage{}
+	_ = json.Unmarshal(data, &out)
+	return resp.StatusCode, out
+}
+
+// challengeOf extrae options.publicKey.challenge de la respuesta de un inicio de ceremonia.
+func challengeOf(t *testing.T, out map[string]json.RawMessage) (ceremonyID, challenge string) {
+	t.Helper()
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(out["options"], &options); err != nil {
+		t.Fatalf("options inválidas: %v", err)
+	}
+	if err := json.Unmarshal(out["ceremony_id"], &ceremonyID); err != nil {
+		t.Fatalf("ceremony_id inválido: %v", err)
+	}
+	return ceremonyID, options.PublicKey.Challenge
+}
+
+func (f *webauthnFixture) register(t *testing.T, auth *softAuthenticator) {
+	t.Helper()
+	status, out := f.post(t, "/mfa/webauthn/register/begin", map[string]string{"totp_code": totpCodeNow(t)})
+	if status != fiber.StatusOK {
+		t.Fatalf("register/begin: estado %d, respuesta %s", status, out["error"])
+	}
+	ceremonyID, challenge := challengeOf(t, out)
+
+	status, out = f.post(t, "/mfa/webauthn/register/finish", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"nombre":      "Llave de prueba",
+		"credential":  auth.create(t, challenge),
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("register/finish: estado %d, respuesta %s", status, out["error"])
+	}
+	if len(f.credentials) != 1 {
+		t.Fatalf("se esperaba 1 credencial guardada, hay %d", len(f.credentials))
+	}
+}
+
+func (f *webauthnFixture) beginLogin(t *testing.T) (ceremonyID, challenge string) {
+	t.Helper()
+	status, out := f.post(t, "/login", map[string]string{})
+	if status != fiber.StatusOK {
+		t.Fatalf("login: estado %d, respuesta %s", status, out["error"])
+	}
+	return challengeOf(t, out)
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	auth := newSoftAuthenticator(t)
+	f.register(t, auth)
+
+	ceremonyID, challenge := f.beginLogin(t)
+	auth.signCount = 1
+	status, out := f.post(t, "/login/webauthn", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"credential":  auth.get(t, challenge, []byte("1")),
+	})
+	if status != fiber.StatusOK {
+		t.Fatalf("login/webauthn: estado %d, respuesta %s", status, out["error"])
+	}
+	if len(out["access_token"]) == 0 || len(out["refresh_token"]) == 0 {
+		t.Fatalf("faltan tokens en la respuesta: %v", out)
+	}
+	if f.sessions != 1 || len(f.failures) != 0 {
+		t.Fatalf("sesiones = %d, fallos = %v; se esperaba 1 y ninguno", f.sessions, f.failures)
+	}
+	if f.ipCredits != 1 {
+		t.Fatalf("un login correcto debe descontar un fallo de la IP, descuentos = %d", f.ipCredits)
+	}
+	if f.purges != 2 {
+		t.Fatalf("se esperaba purgar ceremonias expiradas en cada inicio, purgas = %d", f.purges)
+	}
+
+	var stored webauthn.Credential
+	if err := json.Unmarshal(f.credentials[string(auth.credentialID)], &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Authenticator.SignCount != 1 {
+		t.Fatalf("contador de firmas guardado = %d, se esperaba 1", stored.Authenticator.SignCount)
+	}
+
+	// La ceremonia es de un solo uso
+	status, _ = f.post(t, "/login/webauthn", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"credential":  auth.get(t, challenge, []byte("1")),
+	})
+	if status != fiber.StatusUnauthorized {
+		t.Fatalf("reutilizar la ceremonia: estado %d, se esperaba 401", status)
+	}
+}
+
+func TestWebAuthnLoginWrongChallenge(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	auth := newSoftAuthenticator(t)
+	f.register(t, auth)
+
+	ceremonyID, _ := f.beginLogin(t)
+	auth.signCount = 1
+	status, _ := f.post(t, "/login/webauthn", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"credential":  auth.get(t, b64url.EncodeToString([]byte("desafio-que-no-emitio-el-servidor")), []byte("1")),
+	})
+	if status != fiber.StatusUnauthorized {
+		t.Fatalf("estado %d, se esperaba 401", status)
+	}
+	if f.sessions != 0 || f.failures[failureScopeAccount] != 1 || f.failures[failureScopeIP] != 1 {
+		t.Fatalf("sesiones = %d, fallos = %v; se esperaba 0 y un fallo por cuenta y por IP", f.sessions, f.failures)
+	}
+}
+
+func TestWebAuthnLoginCloneWarning(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	auth := newSoftAuthenticator(t)
+	auth.signCount = 5
+	f.register(t, auth)
+
+	// Un contador que retrocede indica que otra copia de la llave ya lo avanzó
+	ceremonyID, challenge := f.beginLogin(t)
+	auth.signCount = 3
+	status, _ := f.post(t, "/login/webauthn", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"credential":  auth.get(t, challenge, []byte("1")),
+	})
+	if status != fiber.StatusUnauthorized {
+		t.Fatalf("estado %d, se esperaba 401", status)
+	}
+	if f.sessions != 0 || f.failures[failureScopeAccount] != 1 || f.failures[failureScopeIP] != 1 {
+		t.Fatalf("sesiones = %d, fallos = %v; se esperaba 0 y un fallo por cuenta y por IP", f.sessions, f.failures)
+	}
+}
+
+func TestWebAuthnLoginInactiveAccount(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	auth := newSoftAuthenticator(t)
+	f.register(t, auth)
+
+	ceremonyID, challenge := f.beginLogin(t)
+	f.activo = false
+	auth.signCount = 1
+	status, _ := f.post(t, "/login/webauthn", map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"credential":  auth.get(t, challenge, []byte("1")),
+	})
+	if status != fiber.StatusForbidden {
+		t.Fatalf("estado %d, se esperaba 403", status)
+	}
+	if f.sessions != 0 {
+		t.Fatalf("se emitieron %d sesiones para una cuenta desactivada", f.sessions)
+	}
+}
+
+func TestWebAuthnRegistrationWrongFactor(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	status, _ := f.post(t, "/mfa/webauthn/register/begin", map[string]string{"totp_code": "000000"})
+	if status != fiber.StatusUnauthorized {
+		t.Fatalf("estado %d, se esperaba 401", status)
+	}
+	if len(f.ceremonies) != 0 || f.failures[failureScopeAccount] != 1 || f.failures[failureScopeIP] != 1 {
+		t.Fatalf("ceremonias = %d, fallos = %v; se esperaba 0 y un fallo por cuenta y por IP", len(f.ceremonies), f.failures)
+	}
+}
+
+func TestDeleteWebAuthnCredentialRequiresFactor(t *testing.T) {
+	f := newWebAuthnFixture(t)
+	f.register(t, newSoftAuthenticator(t))
+
+	status, _ := f.do(t, "DELETE", "/mfa/webauthn/credentials/1", map[string]string{})
+	if status != fiber.StatusUnauthorized {
+		t.Fatalf("sin segundo factor: estado %d, se esperaba 401", status)
+	}
+	if len(f.credentials) != 1 || f.failures[failureScopeAccount] != 1 {
+		t.Fatalf("llaves = %d, fallos = %v; la llave no debe eliminarse y el intento debe contar", len(f.credentials), f.failures)
+	}
+
+	status, out := f.do(t, "DELETE", "/mfa/webauthn/credentials/1", map[string]string{"totp_code": totpCodeNow(t)})
+	if status != fiber.StatusOK {
+		t.Fatalf("con segundo factor: estado %d, respuesta %s", status, out["error"])
+	}
+	if len(f.credentials) != 0 {
+		t.Fatalf("quedan %d llaves, se esperaba eliminarla", len(f.credentials))
+	}
+}
@@ -0,0 +1,22 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSender escribe cada mensaje como archivo .eml en Dir; pensado para desarrollo local.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileSender) Send(msg Message) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(s.Dir, name), format(s.From, msg), 0o600)
+}
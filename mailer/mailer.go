@@ -0,0 +1,35 @@
+// Package mailer define el envío de correos de la aplicación y sus implementaciones:
+// SMTP para producción, archivos .eml para desarrollo local y memoria para pruebas.
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message es un correo de texto plano.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender envía mensajes. Las implementaciones deben ser seguras para uso concurrente.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// format serializa msg como un correo RFC 5322 mínimo.
+func format(from string, msg Message) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %s\r\n", from)
+	fmt.Fprintf(&sb, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&sb, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&sb, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(sb.String())
+}
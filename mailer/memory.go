@@ -0,0 +1,23 @@
+package mailer
+
+import "sync"
+
+// MemorySender guarda los mensajes en memoria para inspeccionarlos en pruebas.
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *MemorySender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// Messages devuelve una copia de los mensajes enviados hasta ahora.
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
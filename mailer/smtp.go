@@ -0,0 +1,23 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SMTPSender envía por SMTP con autenticación PLAIN (STARTTLS si el servidor lo ofrece).
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{msg.To}, format(s.From, msg))
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"hospitalaria/config"
+	"hospitalaria/handlers"
+	"hospitalaria/middleware"
 	"hospitalaria/routes"
 	"hospitalaria/utils"
 )
@@ -18,7 +22,55 @@ func main() {
 
 	defer config.Conn.Close()
 
-	app := fiber.New()
+	if err := config.InitJWTKeys(); err != nil {
+		log.Fatal("No se pudieron cargar las claves JWT:", err)
+	}
+
+	if err := config.InitWebAuthn(); err != nil {
+		log.Fatal("No se pudo configurar WebAuthn:", err)
+	}
+
+	if err := config.InitMailer(); err != nil {
+		log.Fatal("No se pudo configurar el correo:", err)
+	}
+
+	if err := config.InitPasswordPolicy(); err != nil {
+		log.Fatal("No se pudo cargar la política de contraseñas:", err)
+	}
+
+	if err := config.InitPasswordHasher(); err != nil {
+		log.Fatal("No se pudo configurar el hash de contraseñas:", err)
+	}
+
+	if err := config.InitTOTP(); err != nil {
+		log.Fatal("No se pudo configurar TOTP:", err)
+	}
+
+	if err := config.InitTOTPEncryption(); err != nil {
+		log.Fatal("No se pudieron cargar las claves de cifrado TOTP:", err)
+	}
+
+	// Cifra los secretos TOTP en texto plano y los cifrados con claves anteriores
+	if n, err := handlers.ReencryptTOTPSecrets(context.Background()); err != nil {
+		log.Fatal("No se pudieron volver a cifrar los secretos TOTP:", err)
+	} else if n > 0 {
+		log.Printf("Secretos TOTP cifrados de nuevo: %d", n)
+	}
+
+	if err := config.InitOIDC(); err != nil {
+		log.Fatal("No se pudo configurar OIDC:", err)
+	}
+
+	// Purga de la lista de revocación de access tokens
+	middleware.StartRevokedTokenPurge(15 * time.Minute)
+	// Purga de los estados de login OIDC que nunca volvieron del IdP
+	handlers.StartOIDCStatePurge(15 * time.Minute)
+
+	serverConfig, err := config.ServerConfig()
+	if err != nil {
+		log.Fatal("Configuración de proxy inválida:", err)
+	}
+	app := fiber.New(serverConfig)
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("¡Bienvenido al backend del Sistema de Citas y Reportes del Hospital!")
 	})
@@ -28,6 +80,7 @@ func main() {
 	routes.SetupPacienteRoutes(app)
 	routes.SetupMedicoRoutes(app)
 	routes.SetupEnfermeraRoutes(app)
+	routes.SetupAdminRoutes(app)
 
 	log.Fatal(app.Listen(":3000"))
 }
\ No newline at end of file
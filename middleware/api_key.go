@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/apikeys"
+	"hospitalaria/utils"
+)
+
+// APIKeyHeader es la cabecera con la que los sistemas externos envían su clave.
+const APIKeyHeader = "X-API-Key"
+
+// JWTOrAPIKey acepta un access token (igual que JWTProtected) o una clave de API en X-API-Key.
+// Con clave, user_id es 0, role queda vacío y la clave se deja en Locals("api_key"); los permisos
+// salen de sus scopes. Cada petición con clave se audita como api_key_request con su prefijo.
+func JWTOrAPIKey() fiber.Handler {
+	jwtProtected := JWTProtected()
+	return func(c *fiber.Ctx) error {
+		raw := c.Get(APIKeyHeader)
+		if raw == "" {
+			return jwtProtected(c)
+		}
+
+		key, err := apikeys.Authenticate(context.Background(), raw)
+		if errors.Is(err, apikeys.ErrInvalid) {
+			utils.LogAction(0, "api_key_request", "fallido",
+				"Clave inválida, revocada o expirada (prefijo "+apikeys.PrefixOf(raw)+") desde "+c.IP()+" en "+c.Method()+" "+c.Path())
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Clave de API inválida"})
+		}
+		if err != nil {
+			log.Printf("Error al validar clave de API: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al validar clave de API"})
+		}
+
+		c.Locals("user_id", 0)
+		c.Locals("role", "")
+		c.Locals("api_key", key)
+
+		err = c.Next()
+		status := c.Response().StatusCode()
+		result := "exitoso"
+		if err != nil || status >= fiber.StatusBadRequest {
+			result = "fallido"
+		}
+		utils.LogAction(0, "api_key_request", result,
+			"Clave "+key.Prefix+" ("+key.Nombre+") desde "+c.IP()+": "+c.Method()+" "+c.Path()+" -> "+strconv.Itoa(status))
+		return err
+	}
+}
+
+// apiKeyFrom devuelve la clave de API de la petición, o nil si se autenticó con JWT.
+func apiKeyFrom(c *fiber.Ctx) *apikeys.Key {
+	key, _ := c.Locals("api_key").(*apikeys.Key)
+	return key
+}
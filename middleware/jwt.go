@@ -3,7 +3,8 @@ package middleware
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
-	"os"
+	"hospitalaria/config"
+	"time"
 )
 
 func JWTProtected() fiber.Handler {
@@ -18,9 +19,8 @@ func JWTProtected() fiber.Handler {
 			tokenStr = tokenStr[7:]
 		}
 
-		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		// La clave se elige por kid y el alg debe coincidir con el de esa clave
+		token, err := jwt.Parse(tokenStr, config.JWTKeys.Keyfunc)
 
 		if err != nil || !token.Valid {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token inválido"})
@@ -43,9 +43,31 @@ func JWTProtected() fiber.Handler {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "role no encontrado en token"})
 		}
 
+		// Solo se aceptan access tokens; los refresh tokens únicamente sirven en /refresh-token
+		if tokenType, _ := claims["token_type"].(string); tokenType != "access" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token inválido"})
+		}
+
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "jti no encontrado en token"})
+		}
+		revoked, err := isTokenRevoked(jti)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al validar token"})
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token revocado"})
+		}
+		familyID, _ := claims["family_id"].(string)
+		expiry, _ := claims["exp"].(float64)
+
 		// Almacenar como int para user_id
 		c.Locals("user_id", userID)
 		c.Locals("role", role)
+		c.Locals("jti", jti)
+		c.Locals("family_id", familyID)
+		c.Locals("token_exp", time.Unix(int64(expiry), 0))
 
 		return c.Next()
 	}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/access"
+	"hospitalaria/utils"
+)
+
+// RequirePatientAccess exige una relación de atención con el paciente del parámetro :id (ver access.PatientAccess).
+// Deja el id en Locals("id_paciente"). Debe ir después de JWTProtected o JWTOrAPIKey.
+func RequirePatientAccess() fiber.Handler {
+	return patientAccess(false)
+}
+
+// RequireExpedienteAccess es RequirePatientAccess para la ruta del expediente: sin relación de atención
+// acepta además un acceso de emergencia vigente (access.EmergencyAccess), que se audita como alerta.
+func RequireExpedienteAccess() fiber.Handler {
+	return patientAccess(true)
+}
+
+func patientAccess(allowEmergency bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("user_id").(int)
+		role, _ := c.Locals("role").(string)
+		idPaciente, err := c.ParamsInt("id")
+		if err != nil || idPaciente <= 0 {
+			utils.LogAction(userID, "patient_access", "fallido", "ID de paciente inválido: "+c.Params("id"))
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ID de paciente inválido"})
+		}
+
+		var grant access.Grant
+		if key := apiKeyFrom(c); key != nil {
+			// Las integraciones no tienen relación de atención: su acceso lo limitan los scopes de la clave
+			grant = access.Grant{Allowed: true, Relation: access.RelationService + " (clave " + key.Prefix + ")"}
+		} else {
+			grant, err = access.PatientAccess(context.Background(), userID, role, idPaciente)
+			if err == nil && !grant.Allowed && allowEmergency {
+				grant, err = access.EmergencyAccess(context.Background(), userID, idPaciente)
+			}
+		}
+		if err != nil {
+			log.Printf("Error al evaluar acceso a paciente: %v", err)
+			utils.LogAction(userID, "patient_access", "fallido", "Error al evaluar acceso: "+err.Error())
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error al validar acceso"})
+		}
+		target := "paciente ID " + strconv.Itoa(idPaciente) + " en " + c.Method() + " " + c.Path()
+		if !grant.Allowed {
+			utils.LogAction(userID, "patient_access", "fallido", "Sin relación de atención vigente con "+target)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
+		}
+		if grant.Relation == access.RelationEmergency {
+			utils.LogAlert(userID, "patient_access", "exitoso", "Acceso de emergencia a "+target+
+				" (vigente hasta "+grant.Until.Format(time.RFC3339)+")")
+		} else {
+			utils.LogAction(userID, "patient_access", "exitoso", "Acceso como "+grant.Relation+" a "+target)
+		}
+
+		c.Locals("id_paciente", idPaciente)
+		return c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/policy"
+	"hospitalaria/utils"
+)
+
+// RequirePermission rechaza con 403 las peticiones cuyo rol no tiene perm. Debe ir después de JWTProtected.
+// Con una clave de API (JWTOrAPIKey) se exige perm entre los scopes de la clave.
+func RequirePermission(perm policy.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if key := apiKeyFrom(c); key != nil {
+			if !key.Allows(perm) {
+				utils.LogAction(0, "authorize", "fallido",
+					"Permiso denegado: clave "+key.Prefix+" sin "+string(perm)+" en "+c.Method()+" "+c.Path())
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
+			}
+			return c.Next()
+		}
+		userID, _ := c.Locals("user_id").(int)
+		role, _ := c.Locals("role").(string)
+		if !policy.Allowed(role, perm) {
+			utils.LogAction(userID, "authorize", "fallido",
+				"Permiso denegado: rol "+role+" sin "+string(perm)+" en "+c.Method()+" "+c.Path())
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Permiso denegado"})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/apikeys"
+	"hospitalaria/policy"
+)
+
+func TestRequirePermission(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		locals func(c *fiber.Ctx)
+		want   int
+	}{
+		{"rol con permiso", func(c *fiber.Ctx) { c.Locals("role", "Medico") }, fiber.StatusOK},
+		{"rol sin permiso", func(c *fiber.Ctx) { c.Locals("role", "Administrador") }, fiber.StatusForbidden},
+		{"rol desconocido", func(c *fiber.Ctx) { c.Locals("role", "Invitado") }, fiber.StatusForbidden},
+		{"sin rol", func(c *fiber.Ctx) {}, fiber.StatusForbidden},
+		{"clave con el scope", func(c *fiber.Ctx) {
+			c.Locals("api_key", &apikeys.Key{Prefix: "abc", Scopes: []policy.Permission{policy.CitasLeer}})
+		}, fiber.StatusOK},
+		{"clave sin el scope", func(c *fiber.Ctx) {
+			c.Locals("role", "Medico")
+			c.Locals("api_key", &apikeys.Key{Prefix: "abc", Scopes: []policy.Permission{policy.ConsultasLeer}})
+		}, fiber.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached := false
+			app := fiber.New()
+			app.Get("/citas", func(c *fiber.Ctx) error {
+				c.Locals("user_id", 1)
+				tc.locals(c)
+				return c.Next()
+			}, RequirePermission(policy.CitasLeer), func(c *fiber.Ctx) error {
+				reached = true
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/citas", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tc.want {
+				t.Fatalf("estado %d, se esperaba %d", resp.StatusCode, tc.want)
+			}
+			if reached != (tc.want == fiber.StatusOK) {
+				t.Fatalf("el siguiente handler se ejecutó = %v con estado %d", reached, resp.StatusCode)
+			}
+		})
+	}
+}
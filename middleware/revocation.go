@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hospitalaria/config"
+)
+
+// isTokenRevoked consulta la lista de revocación por jti.
+func isTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	err := config.Conn.QueryRow(context.Background(),
+		"SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+// StartRevokedTokenPurge elimina periódicamente las entradas de revocación cuyo token ya expiró.
+func StartRevokedTokenPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result, err := config.Conn.Exec(context.Background(), "DELETE FROM revoked_tokens WHERE expires_at < now()")
+			if err != nil {
+				log.Printf("Error al purgar tokens revocados: %v", err)
+				continue
+			}
+			if result.RowsAffected() > 0 {
+				log.Printf("Tokens revocados purgados: %d", result.RowsAffected())
+			}
+		}
+	}()
+}
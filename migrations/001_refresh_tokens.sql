@@ -0,0 +1,20 @@
+-- Almacén de refresh tokens con rotación de un solo uso.
+-- Cada inicio de sesión abre una familia (family_id); cada renovación
+-- marca el token usado y emite uno nuevo dentro de la misma familia.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id_refresh_token SERIAL PRIMARY KEY,
+    jti TEXT NOT NULL UNIQUE,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    replaced_by TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_usuario ON refresh_tokens (id_usuario);
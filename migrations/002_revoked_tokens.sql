@@ -0,0 +1,15 @@
+-- Lista de revocación de access tokens, indexada por jti.
+-- Las entradas caducan junto con el token y se purgan periódicamente.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires ON revoked_tokens (expires_at);
+
+-- Cada refresh token recuerda el access token emitido junto con él,
+-- para poder revocar ambos al cerrar la sesión.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS access_jti TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS access_expires_at TIMESTAMPTZ;
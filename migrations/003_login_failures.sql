@@ -0,0 +1,10 @@
+-- Contadores de intentos fallidos de inicio de sesión.
+-- scope = 'cuenta' usa el id_usuario como subject; scope = 'ip' usa la dirección IP.
+CREATE TABLE IF NOT EXISTS login_failures (
+    scope TEXT NOT NULL CHECK (scope IN ('cuenta', 'ip')),
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
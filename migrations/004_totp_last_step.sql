@@ -0,0 +1,2 @@
+-- Último paso temporal TOTP aceptado por usuario; un código solo puede usarse una vez.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
@@ -0,0 +1,10 @@
+-- Códigos de recuperación MFA de un solo uso, almacenados con bcrypt.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id_recovery_code SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_usuario ON recovery_codes (id_usuario);
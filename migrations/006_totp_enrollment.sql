@@ -0,0 +1,5 @@
+-- Reinscripción TOTP: el secreto nuevo queda pendiente hasta confirmarse con un código válido.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS totp_pending_secret TEXT;
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS totp_pending_at TIMESTAMPTZ;
+-- Activado por un administrador: el siguiente login exige inscribir un secreto nuevo.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS totp_reset_required BOOLEAN NOT NULL DEFAULT false;
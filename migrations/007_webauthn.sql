@@ -0,0 +1,21 @@
+-- Credenciales WebAuthn (llaves de seguridad y autenticadores de plataforma) por usuario.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id_credencial SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL UNIQUE,
+    credential JSONB NOT NULL,
+    nombre TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_usuario ON webauthn_credentials (id_usuario);
+
+-- Datos de sesión de cada ceremonia (registro o login) entre el inicio y la respuesta del autenticador.
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id_ceremonia TEXT PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    ceremony TEXT NOT NULL CHECK (ceremony IN ('registro', 'login')),
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
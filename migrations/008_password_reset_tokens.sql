@@ -0,0 +1,12 @@
+-- Tokens de restablecimiento de contraseña: de un solo uso, de corta duración y guardados como SHA-256.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id_reset SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_usuario ON password_reset_tokens (id_usuario);
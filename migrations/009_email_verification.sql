@@ -0,0 +1,17 @@
+-- Verificación de correo. Las cuentas existentes se consideran verificadas;
+-- las nuevas se crean sin verificar.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS correo_verificado BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE usuarios ALTER COLUMN correo_verificado SET DEFAULT false;
+
+-- Tokens de verificación, ligados al correo que verifican.
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id_verificacion SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    correo TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_usuario ON email_verification_tokens (id_usuario);
@@ -0,0 +1,9 @@
+-- Historial de hashes de contraseña para impedir reutilizar las últimas N contraseñas.
+CREATE TABLE IF NOT EXISTS password_history (
+    id_historial SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_usuario ON password_history (id_usuario, created_at DESC);
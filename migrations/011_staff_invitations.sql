@@ -0,0 +1,15 @@
+-- Invitaciones para dar de alta personal clínico. El token enviado por correo es un JWT firmado
+-- cuyo jti identifica la fila; la fila permite revocarla y garantiza que se use una sola vez.
+CREATE TABLE IF NOT EXISTS staff_invitations (
+    jti TEXT PRIMARY KEY,
+    correo TEXT NOT NULL,
+    rol TEXT NOT NULL,
+    invitado_por INTEGER REFERENCES usuarios(id_usuario) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    id_usuario INTEGER REFERENCES usuarios(id_usuario) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_invitations_correo ON staff_invitations (correo);
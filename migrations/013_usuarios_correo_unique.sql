@@ -0,0 +1,3 @@
+-- Un correo por cuenta: el registro responde 409 ante duplicados en lugar de crear otra cuenta.
+-- Si ya existen duplicados deben resolverse antes de aplicar esta migración.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_usuarios_correo ON usuarios (correo);
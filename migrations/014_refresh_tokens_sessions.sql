@@ -0,0 +1,2 @@
+-- Listado de sesiones (GET /sessions): agrupa los refresh tokens de cada usuario por familia.
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_usuario_family ON refresh_tokens (id_usuario, family_id);
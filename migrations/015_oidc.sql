@@ -0,0 +1,22 @@
+-- Identidades del proveedor OIDC corporativo vinculadas a cuentas locales (solo personal).
+CREATE TABLE IF NOT EXISTS oidc_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario) ON DELETE CASCADE,
+    correo TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oidc_identities_usuario ON oidc_identities (issuer, id_usuario);
+
+-- Estado de cada login OIDC entre la redirección al IdP y el callback: el code_verifier PKCE
+-- y el nonce nunca salen del servidor. Cada fila se consume una sola vez.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    code_verifier TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL
+);
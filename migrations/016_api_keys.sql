@@ -0,0 +1,14 @@
+-- Claves de API para integraciones entre servicios. Solo se guarda el SHA-256 de la clave;
+-- el prefijo la identifica en listados y auditoría. scopes son permisos de policy.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id_clave SERIAL PRIMARY KEY,
+    prefix TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL UNIQUE,
+    nombre TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    creado_por INTEGER REFERENCES usuarios(id_usuario) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
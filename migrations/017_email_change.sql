@@ -0,0 +1,4 @@
+-- Cambio de correo desde PATCH /profile: el token de verificación indica si confirma el correo
+-- del registro o un correo nuevo que solo se aplica al verificarlo.
+ALTER TABLE email_verification_tokens ADD COLUMN IF NOT EXISTS tipo TEXT NOT NULL DEFAULT 'registro'
+    CHECK (tipo IN ('registro', 'cambio_correo'));
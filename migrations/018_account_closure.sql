@@ -0,0 +1,4 @@
+-- Cierre de cuenta por el titular: el login queda desactivado y los datos clínicos se conservan
+-- hasta retencion_hasta, según el plazo legal de conservación del expediente.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS cerrado_at TIMESTAMPTZ;
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS retencion_hasta DATE;
@@ -0,0 +1,15 @@
+-- Accesos de emergencia ("romper el cristal") al expediente de un paciente sin relación de
+-- atención. Cada fila es un acceso temporal con su motivo; se conservan para la revisión de
+-- cumplimiento aunque hayan caducado.
+CREATE TABLE IF NOT EXISTS break_glass_access (
+    id_acceso SERIAL PRIMARY KEY,
+    id_usuario INTEGER NOT NULL REFERENCES usuarios(id_usuario),
+    id_paciente INTEGER NOT NULL REFERENCES pacientes(id_paciente),
+    motivo TEXT NOT NULL,
+    ip TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS break_glass_access_usuario_paciente_idx
+    ON break_glass_access (id_usuario, id_paciente, expires_at);
@@ -0,0 +1,2 @@
+-- Índice para purgar las ceremonias WebAuthn caducadas al iniciar cada ceremonia nueva.
+CREATE INDEX IF NOT EXISTS idx_webauthn_ceremonies_expires ON webauthn_ceremonies (expires_at);
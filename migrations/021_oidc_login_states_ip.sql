@@ -0,0 +1,3 @@
+-- Índices para limitar los logins OIDC pendientes por IP y purgar los estados expirados.
+CREATE INDEX IF NOT EXISTS idx_oidc_login_states_ip ON oidc_login_states (ip, expires_at);
+CREATE INDEX IF NOT EXISTS idx_oidc_login_states_expires ON oidc_login_states (expires_at);
@@ -0,0 +1,9 @@
+-- Los correos se comparan sin distinguir mayúsculas: el índice único pasa a lower(correo) para que
+-- Ana@Hospital.com y ana@hospital.com no puedan ser dos cuentas, y las búsquedas por lower(correo) lo usen.
+-- Si ya existen correos que solo difieren en mayúsculas deben resolverse antes de aplicar esta migración.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_usuarios_correo_lower ON usuarios (lower(correo));
+DROP INDEX IF EXISTS idx_usuarios_correo;
+
+-- Los handlers guardan ya el correo normalizado; se normalizan también los existentes
+UPDATE usuarios SET correo = lower(trim(correo)) WHERE correo <> lower(trim(correo));
+UPDATE staff_invitations SET correo = lower(trim(correo)) WHERE correo <> lower(trim(correo));
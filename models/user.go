@@ -7,11 +7,25 @@ type User struct {
 	Correo         string `json:"correo"`
 	Contraseña     string `json:"contraseña,omitempty"`
 	Rol            string `json:"rol"`
-	Totp_secret    string `json:"totp_secret,omitempty"`
+	Totp_secret    string `json:"-"` // cifrado; nunca se serializa
+	CorreoVerificado bool `json:"correo_verificado"`
+	Activo         bool   `json:"activo"`
 	FechaNacimiento string `json:"fecha_nacimiento,omitempty"`
 	Genero         string `json:"genero,omitempty"`
 	Direccion      string `json:"direccion,omitempty"`
 	Especialidad   string `json:"especialidad,omitempty"`
 	NumeroColegiado string `json:"numero_colegiado,omitempty"`
 	Certificacion  string `json:"certificacion,omitempty"`
-}
\ No newline at end of file
+}
+
+// Roles son los valores admitidos en usuarios.rol.
+var Roles = []string{"Paciente", "Medico", "Enfermero", "Administrador"}
+
+func IsValidRole(rol string) bool {
+	for _, r := range Roles {
+		if r == rol {
+			return true
+		}
+	}
+	return false
+}
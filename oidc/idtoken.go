@@ -0,0 +1,179 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims son los datos del ID token que usa la aplicación.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// clockSkew tolera pequeñas diferencias de reloj con el IdP.
+const clockSkew = time.Minute
+
+// VerifyIDToken valida firma, iss, aud, exp y nonce del ID token.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	if _, err := p.Discover(ctx); err != nil {
+		return nil, err
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}), jwt.WithoutClaimsValidation())
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.get(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, fmt.Errorf("iss inesperado: %q", iss)
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("aud no incluye el client_id")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true) {
+		return nil, errors.New("ID token expirado")
+	}
+	if !claims.VerifyIssuedAt(now.Add(clockSkew).Unix(), false) {
+		return nil, errors.New("iat en el futuro")
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("nonce inválido")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("sub vacío")
+	}
+
+	c := &Claims{Subject: sub}
+	c.Email, _ = claims["email"].(string)
+	c.Name, _ = claims["name"].(string)
+	// Algunos IdP envían email_verified como cadena
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		c.EmailVerified = v
+	case string:
+		c.EmailVerified = v == "true"
+	}
+	return c, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// keyCache guarda las claves del JWKS del IdP y lo vuelve a descargar ante un kid desconocido,
+// como mucho una vez por minuto, para seguir las rotaciones de claves del IdP.
+type keyCache struct {
+	provider  *Provider
+	uri       string
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func (k *keyCache) get(ctx context.Context, kid string) (interface{}, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(k.fetchedAt) < time.Minute && k.keys != nil {
+		return nil, fmt.Errorf("kid desconocido: %q", kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := k.provider.getJSON(ctx, k.uri, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := j.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[j.Kid] = key
+	}
+	k.keys, k.fetchedAt = keys, time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// Un IdP con una única clave puede omitir kid
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("kid desconocido: %q", kid)
+}
+
+func (j jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("curva no soportada: %s", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("curva no soportada: %s", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("clave Ed25519 inválida")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("tipo de clave no soportado: %s", j.Kty)
+}
@@ -0,0 +1,185 @@
+// Package oidc implementa el flujo authorization code + PKCE de OpenID Connect contra un
+// proveedor de identidad externo: descubrimiento, intercambio del código y validación del ID token.
+// No depende de la base de datos, por lo que puede probarse contra un IdP local de prueba.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery es el subconjunto usado del documento /.well-known/openid-configuration.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider es un IdP configurado. El descubrimiento se hace en el primer uso y se reutiliza.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      *keyCache
+}
+
+// NewProvider crea un Provider con los scopes openid, email y profile.
+func NewProvider(issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondió %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Discover obtiene y valida el documento de descubrimiento del IdP.
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d Discovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("issuer del descubrimiento %q no coincide con %q", d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("documento de descubrimiento incompleto")
+	}
+	p.discovery = &d
+	p.keys = &keyCache{provider: p, uri: d.JWKSURI}
+	return p.discovery, nil
+}
+
+// AuthCodeURL construye la URL de autorización con state, nonce y el reto PKCE (S256).
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange canjea el código de autorización y devuelve el ID token sin validar.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	d, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("respuesta del token endpoint inválida (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("token endpoint respondió %s: %s %s", resp.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("la respuesta no incluye id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// NewCodeVerifier genera un code_verifier PKCE de 43 caracteres.
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallengeS256 calcula el code_challenge S256 de verifier.
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewState genera un valor aleatorio apto para state o nonce.
+func NewState() (string, error) {
+	return randomString(32)
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
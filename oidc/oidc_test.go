@@ -0,0 +1,312 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID     = "hospitalaria"
+	testClientSecret = "secreto"
+	testRedirectURL  = "http://localhost:3000/oidc/callback"
+	testKeyID        = "clave-1"
+)
+
+// stubIdP es un proveedor OIDC mínimo: descubrimiento, JWKS con una clave ES256 y un token
+// endpoint que solo acepta el code_verifier cuyo reto se registró para el código.
+type stubIdP struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+
+	mu         sync.Mutex
+	issuer     string // issuer publicado en el descubrimiento; por defecto la URL del servidor
+	discovery  int
+	challenges map[string]string // código -> code_challenge
+	idToken    string            // ID token que entrega el token endpoint
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &stubIdP{key: key, challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.discovery++
+		issuer := idp.issuer
+		idp.mu.Unlock()
+		if issuer == "" {
+			issuer = idp.server.URL
+		}
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": testKeyID,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.PublicKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.PublicKey.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != testClientID || pass != testClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != testRedirectURL {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	idp.mu.Lock()
+	challenge, ok := idp.challenges[r.PostForm.Get("code")]
+	delete(idp.challenges, r.PostForm.Get("code"))
+	idToken := idp.idToken
+	idp.mu.Unlock()
+	if !ok || CodeChallengeS256(r.PostForm.Get("code_verifier")) != challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE inválido"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// authorize simula que el usuario se autenticó en authURL: registra el reto PKCE y devuelve el código.
+func (idp *stubIdP) authorize(t *testing.T, authURL string) (code string, params url.Values) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params = u.Query()
+	code = "codigo-" + params.Get("state")
+	idp.mu.Lock()
+	idp.challenges[code] = params.Get("code_challenge")
+	idp.mu.Unlock()
+	return code, params
+}
+
+// claims devuelve los claims de un ID token válido para el cliente de prueba.
+func (idp *stubIdP) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            testClientID,
+		"sub":            "empleado-42",
+		"email":          "medico@hospital.com",
+		"email_verified": true,
+		"name":           "Dra. Pérez",
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims jwt.MapClaims, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = testKeyID
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (idp *stubIdP) provider() *Provider {
+	p := NewProvider(idp.server.URL+"/", testClientID, testClientSecret, testRedirectURL)
+	p.HTTPClient = idp.server.Client()
+	return p
+}
+
+func TestDiscover(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	d, err := p.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.TokenEndpoint != idp.server.URL+"/token" || d.JWKSURI != idp.server.URL+"/jwks" {
+		t.Fatalf("descubrimiento inesperado: %+v", d)
+	}
+	if _, err := p.Discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if idp.discovery != 1 {
+		t.Fatalf("el descubrimiento se pidió %d veces, se esperaba 1", idp.discovery)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newStubIdP(t)
+	idp.issuer = "https://otro-idp.example.com"
+	if _, err := idp.provider().Discover(context.Background()); err == nil {
+		t.Fatal("se aceptó un descubrimiento con otro issuer")
+	}
+}
+
+func TestAuthCodeFlowWithPKCE(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	state, _ := NewState()
+	nonce, _ := NewState()
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier) != 43 {
+		t.Fatalf("code_verifier de %d caracteres, se esperaban 43", len(verifier))
+	}
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatalf("URL de autorización inesperada: %s", authURL)
+	}
+	code, params := idp.authorize(t, authURL)
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 state,
+		"nonce":                 nonce,
+		"code_challenge_method": "S256",
+	} {
+		if got := params.Get(name); got != want {
+			t.Errorf("%s = %q, se esperaba %q", name, got, want)
+		}
+	}
+
+	idp.idToken = idp.sign(t, idp.claims(nonce), idp.key)
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "empleado-42", Email: "medico@hospital.com", EmailVerified: true, Name: "Dra. Pérez"}
+	if *claims != want {
+		t.Fatalf("claims = %+v, se esperaba %+v", *claims, want)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	verifier, _ := NewCodeVerifier()
+	other, _ := NewCodeVerifier()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", CodeChallengeS256(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := idp.authorize(t, authURL)
+	idp.idToken = idp.sign(t, idp.claims("nonce"), idp.key)
+	if _, err := p.Exchange(ctx, code, other); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange con otro code_verifier: err = %v, se esperaba invalid_grant", err)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	idp := newStubIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+	const nonce = "nonce-esperado"
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		key    *ecdsa.PrivateKey
+		nonce  *string // nonce esperado por el cliente; nil usa el del token
+	}{
+		{name: "firma de otra clave", key: otherKey},
+		{name: "aud de otro cliente", mutate: func(c jwt.MapClaims) { c["aud"] = "otro-cliente" }},
+		{name: "iss de otro IdP", mutate: func(c jwt.MapClaims) { c["iss"] = "https://otro-idp.example.com" }},
+		{name: "expirado", mutate: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }},
+		{name: "sin exp", mutate: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "nonce distinto", nonce: ptr("otro-nonce")},
+		// Un nonce vacío nunca coincide, aunque el cliente tampoco tenga uno guardado
+		{name: "sin nonce", mutate: func(c jwt.MapClaims) { delete(c, "nonce") }, nonce: ptr("")},
+		{name: "sin sub", mutate: func(c jwt.MapClaims) { delete(c, "sub") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := idp.claims(nonce)
+			if tc.mutate != nil {
+				tc.mutate(claims)
+			}
+			key := idp.key
+			if tc.key != nil {
+				key = tc.key
+			}
+			expected := nonce
+			if tc.nonce != nil {
+				expected = *tc.nonce
+			}
+			if _, err := p.VerifyIDToken(ctx, idp.sign(t, claims, key), expected); err == nil {
+				t.Fatal("se aceptó el ID token")
+			}
+		})
+	}
+
+	// Un token sin firma (alg none) tampoco se acepta
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, idp.claims(nonce)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(ctx, unsigned, nonce); err == nil {
+		t.Fatal("se aceptó un ID token sin firma")
+	}
+}
+
+func ptr(s string) *string { return &s }
@@ -0,0 +1,133 @@
+// Package passwordhash genera y verifica hashes de contraseña en formato autodescriptivo:
+// argon2id en formato PHC ($argon2id$v=19$m=...,t=...,p=...$sal$hash) y bcrypt ($2a$/$2b$).
+// Cada hash lleva su algoritmo y parámetros, por lo que pueden convivir hashes antiguos y nuevos
+// y NeedsRehash detecta los que deben actualizarse.
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// MaxBcryptBytes es la longitud máxima que admite bcrypt; con una contraseña más larga Hash falla.
+const MaxBcryptBytes = 72
+
+var (
+	// ErrMismatch indica que la contraseña no corresponde al hash.
+	ErrMismatch = errors.New("la contraseña no coincide")
+	// ErrUnknownFormat indica un hash con algoritmo o formato no reconocido.
+	ErrUnknownFormat = errors.New("formato de hash desconocido")
+)
+
+// Argon2Params son los parámetros de argon2id. Memory se expresa en KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Hasher genera hashes nuevos con Algorithm y sus parámetros.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+// Hash genera el hash de password con el algoritmo configurado.
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case Argon2id:
+		salt := make([]byte, h.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		p := h.Argon2
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(hash), err
+	}
+	return "", fmt.Errorf("algoritmo de hash no soportado: %q", h.Algorithm)
+}
+
+// NeedsRehash indica si encoded usa otro algoritmo o parámetros más débiles que los configurados.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	switch h.Algorithm {
+	case Argon2id:
+		p, _, _, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		return p.Memory < h.Argon2.Memory || p.Iterations < h.Argon2.Iterations ||
+			p.Parallelism < h.Argon2.Parallelism || p.KeyLength < h.Argon2.KeyLength
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost < h.BcryptCost
+	}
+	return false
+}
+
+// Compare verifica password contra encoded, sea cual sea su algoritmo. Devuelve ErrMismatch si no coincide.
+func Compare(encoded, password string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	case strings.HasPrefix(encoded, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+	return ErrUnknownFormat
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", sal, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownFormat
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,129 @@
+package passwordhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parámetros mínimos para que las pruebas sean rápidas
+var testArgon2 = Argon2Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashCompareRoundTrip(t *testing.T) {
+	for _, h := range []*Hasher{
+		{Algorithm: Argon2id, Argon2: testArgon2},
+		{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost},
+	} {
+		encoded, err := h.Hash("Contraseña-Segura-123")
+		if err != nil {
+			t.Fatalf("%s: %v", h.Algorithm, err)
+		}
+		if err := Compare(encoded, "Contraseña-Segura-123"); err != nil {
+			t.Errorf("%s: Compare con la contraseña correcta: %v", h.Algorithm, err)
+		}
+		if err := Compare(encoded, "Contraseña-Segura-124"); !errors.Is(err, ErrMismatch) {
+			t.Errorf("%s: Compare con otra contraseña: err = %v, se esperaba ErrMismatch", h.Algorithm, err)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Errorf("%s: un hash recién generado no debe necesitar rehash", h.Algorithm)
+		}
+	}
+
+	other, err := (&Hasher{Algorithm: Argon2id, Argon2: testArgon2}).Hash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := (&Hasher{Algorithm: Argon2id, Argon2: testArgon2}).Hash("x")
+	if other == again {
+		t.Error("dos hashes de la misma contraseña deben usar sales distintas")
+	}
+}
+
+func TestHashUnknownAlgorithm(t *testing.T) {
+	if _, err := (&Hasher{Algorithm: "md5"}).Hash("x"); err == nil {
+		t.Fatal("se aceptó un algoritmo desconocido")
+	}
+}
+
+func TestBcryptRejectsLongPasswords(t *testing.T) {
+	h := &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+	if _, err := h.Hash(strings.Repeat("a", MaxBcryptBytes)); err != nil {
+		t.Fatalf("%d bytes: %v", MaxBcryptBytes, err)
+	}
+	// Por esto la política limita la longitud (passwordpolicy.MaxLength)
+	if _, err := h.Hash(strings.Repeat("a", MaxBcryptBytes+1)); err == nil {
+		t.Fatalf("bcrypt aceptó %d bytes", MaxBcryptBytes+1)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weakArgon, err := (&Hasher{Algorithm: Argon2id, Argon2: testArgon2}).Hash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakBcrypt, err := (&Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}).Hash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := testArgon2
+	stronger.Iterations = 2
+	moreMemory := testArgon2
+	moreMemory.Memory = 16 * 1024
+
+	for _, tc := range []struct {
+		name    string
+		hasher  *Hasher
+		encoded string
+		want    bool
+	}{
+		{"argon2id con los mismos parámetros", &Hasher{Algorithm: Argon2id, Argon2: testArgon2}, weakArgon, false},
+		{"argon2id con más iteraciones", &Hasher{Algorithm: Argon2id, Argon2: stronger}, weakArgon, true},
+		{"argon2id con más memoria", &Hasher{Algorithm: Argon2id, Argon2: moreMemory}, weakArgon, true},
+		{"bcrypt hacia argon2id", &Hasher{Algorithm: Argon2id, Argon2: testArgon2}, weakBcrypt, true},
+		{"bcrypt con más coste", &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}, weakBcrypt, true},
+		{"bcrypt con el mismo coste", &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, weakBcrypt, false},
+		{"argon2id hacia bcrypt", &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, weakArgon, true},
+		{"hash ilegible", &Hasher{Algorithm: Argon2id, Argon2: testArgon2}, "$argon2id$roto", true},
+	} {
+		if got := tc.hasher.NeedsRehash(tc.encoded); got != tc.want {
+			t.Errorf("%s: NeedsRehash = %v, se esperaba %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMalformedHashes(t *testing.T) {
+	valid, err := (&Hasher{Algorithm: Argon2id, Argon2: testArgon2}).Hash("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	with := func(i int, v string) string {
+		p := append([]string(nil), parts...)
+		p[i] = v
+		return strings.Join(p, "$")
+	}
+	for name, encoded := range map[string]string{
+		"vacío":                "",
+		"texto plano":          "x",
+		"otro algoritmo":       "$argon2i$v=19$m=8192,t=1,p=1$c2FsdA$aGFzaA",
+		"faltan partes":        "$argon2id$v=19$m=8192,t=1,p=1$c2FsdA",
+		"versión distinta":     with(2, "v=16"),
+		"versión ilegible":     with(2, "version"),
+		"parámetros ilegibles": with(3, "m=x,t=1,p=1"),
+		"sal no base64":        with(4, "%%%"),
+		"hash no base64":       with(5, "%%%"),
+		"hash vacío":           with(5, ""),
+	} {
+		if _, _, _, err := decodeArgon2id(encoded); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("decodeArgon2id(%s): err = %v, se esperaba ErrUnknownFormat", name, err)
+		}
+		if err := Compare(encoded, "x"); err == nil || errors.Is(err, ErrMismatch) {
+			t.Errorf("Compare(%s): err = %v, se esperaba un error de formato", name, err)
+		}
+	}
+	if _, _, _, err := decodeArgon2id(valid); err != nil {
+		t.Errorf("decodeArgon2id de un hash válido: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minBlockedWordLength evita que entradas como "abc123" bloqueen cualquier contraseña con "abc".
+const minBlockedWordLength = 4
+
+// Blocklist rechaza contraseñas comunes o filtradas. La comparación ignora mayúsculas y, además de la
+// contraseña exacta, rechaza las que solo añaden dígitos o símbolos a una palabra de la lista
+// ("Password123!!" por "password"): la mayoría de las entradas son más cortas que el mínimo de longitud.
+type Blocklist struct {
+	entries map[string]struct{}
+	words   map[string]struct{}
+}
+
+// blockedWord devuelve solo las letras de s, en minúsculas.
+func blockedWord(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// LoadBlocklist lee una contraseña por línea; se ignoran líneas vacías y las que empiezan con '#'.
+func LoadBlocklist(path string) (*Blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &Blocklist{entries: map[string]struct{}{}, words: map[string]struct{}{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b.entries[strings.ToLower(line)] = struct{}{}
+		if word := blockedWord(line); utf8.RuneCountInString(word) >= minBlockedWordLength {
+			b.words[word] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Len devuelve el número de contraseñas cargadas.
+func (b *Blocklist) Len() int {
+	return len(b.entries)
+}
+
+func (b *Blocklist) Check(password string, _ UserInfo) []string {
+	_, common := b.entries[strings.ToLower(password)]
+	if !common {
+		_, common = b.words[blockedWord(password)]
+	}
+	if common {
+		return []string{"La contraseña es demasiado común o aparece en filtraciones conocidas"}
+	}
+	return nil
+}
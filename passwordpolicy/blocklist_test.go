@@ -0,0 +1,34 @@
+package passwordpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlocklistMatchesWordsPaddedWithDigitsAndSymbols(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("# comentario\npassword\nabc123\n123456789012\nQwerty123\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		password string
+		blocked  bool
+	}{
+		{"PASSWORD", true},
+		{"123456789012", true},
+		{"Password123!!", true},
+		{"!!2024-Qwerty-2024", true},
+		{"p4ssw0rd!2024", false},
+		{"Password-Largo!9", false},
+		{"Abc-9x7-Clave!", false},
+	} {
+		if got := len(b.Check(tc.password, UserInfo{})) > 0; got != tc.blocked {
+			t.Errorf("Check(%q) bloqueada = %v, se esperaba %v", tc.password, got, tc.blocked)
+		}
+	}
+}
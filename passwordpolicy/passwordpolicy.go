@@ -0,0 +1,29 @@
+// Package passwordpolicy valida contraseñas contra un conjunto configurable de reglas
+// y devuelve todas las infracciones a la vez para mostrarlas juntas en el formulario.
+package passwordpolicy
+
+// UserInfo son los datos personales que una contraseña no debe contener.
+type UserInfo struct {
+	Nombre   string
+	Apellido string
+	Correo   string
+}
+
+// Rule es una regla de la política. Check devuelve un mensaje por cada infracción, o nil si se cumple.
+type Rule interface {
+	Check(password string, user UserInfo) []string
+}
+
+// Policy aplica sus reglas en orden.
+type Policy struct {
+	Rules []Rule
+}
+
+// Validate devuelve todas las infracciones de password; una lista vacía significa que es válida.
+func (p *Policy) Validate(password string, user UserInfo) []string {
+	var violations []string
+	for _, rule := range p.Rules {
+		violations = append(violations, rule.Check(password, user)...)
+	}
+	return violations
+}
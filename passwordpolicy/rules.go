@@ -0,0 +1,136 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MinLength exige al menos Min caracteres (runas, no bytes).
+type MinLength struct {
+	Min int
+}
+
+func (r MinLength) Check(password string, _ UserInfo) []string {
+	if utf8.RuneCountInString(password) < r.Min {
+		return []string{fmt.Sprintf("La contraseña debe tener al menos %d caracteres", r.Min)}
+	}
+	return nil
+}
+
+// MaxLength rechaza contraseñas de más de Max bytes. Limita el coste del hash y respeta el máximo
+// de bcrypt, que no admite más de 72 bytes.
+type MaxLength struct {
+	Max int
+}
+
+func (r MaxLength) Check(password string, _ UserInfo) []string {
+	if len(password) > r.Max {
+		return []string{fmt.Sprintf("La contraseña no puede superar %d bytes (los caracteres acentuados ocupan 2)", r.Max)}
+	}
+	return nil
+}
+
+// CharClass es una categoría de caracteres que puede exigirse.
+type CharClass string
+
+const (
+	ClassLower  CharClass = "lower"
+	ClassUpper  CharClass = "upper"
+	ClassDigit  CharClass = "digit"
+	ClassSymbol CharClass = "symbol"
+)
+
+var charClassMessages = map[CharClass]string{
+	ClassLower:  "La contraseña debe incluir minúsculas",
+	ClassUpper:  "La contraseña debe incluir mayúsculas",
+	ClassDigit:  "La contraseña debe incluir números",
+	ClassSymbol: "La contraseña debe incluir símbolos",
+}
+
+// classOf clasifica r. Cualquier carácter que no sea letra ni dígito, incluidos espacios, cuenta como símbolo.
+func classOf(r rune) CharClass {
+	switch {
+	case unicode.IsLower(r):
+		return ClassLower
+	case unicode.IsUpper(r):
+		return ClassUpper
+	case unicode.IsDigit(r):
+		return ClassDigit
+	case unicode.IsLetter(r):
+		// Letras sin mayúscula/minúscula (p. ej. ideogramas) se tratan como minúsculas
+		return ClassLower
+	default:
+		return ClassSymbol
+	}
+}
+
+// ParseCharClass valida el nombre de una clase de caracteres.
+func ParseCharClass(name string) (CharClass, error) {
+	class := CharClass(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := charClassMessages[class]; !ok {
+		return "", fmt.Errorf("clase de caracteres desconocida: %q", name)
+	}
+	return class, nil
+}
+
+// CharClasses exige al menos un carácter de cada clase de Required.
+type CharClasses struct {
+	Required []CharClass
+}
+
+func (r CharClasses) Check(password string, _ UserInfo) []string {
+	present := map[CharClass]bool{}
+	for _, c := range password {
+		present[classOf(c)] = true
+	}
+	var violations []string
+	for _, class := range r.Required {
+		if !present[class] {
+			violations = append(violations, charClassMessages[class])
+		}
+	}
+	return violations
+}
+
+// MaxRepeated rechaza más de Max caracteres idénticos consecutivos.
+type MaxRepeated struct {
+	Max int
+}
+
+func (r MaxRepeated) Check(password string, _ UserInfo) []string {
+	run, last := 0, rune(-1)
+	for _, c := range password {
+		if c == last {
+			run++
+		} else {
+			run, last = 1, c
+		}
+		if run > r.Max {
+			return []string{fmt.Sprintf("La contraseña no puede repetir el mismo carácter más de %d veces seguidas", r.Max)}
+		}
+	}
+	return nil
+}
+
+// minPersonalTokenLength evita rechazar contraseñas por coincidir con nombres muy cortos.
+const minPersonalTokenLength = 3
+
+// NoPersonalInfo rechaza contraseñas que contienen el nombre, el apellido o el correo del usuario.
+type NoPersonalInfo struct{}
+
+func (NoPersonalInfo) Check(password string, user UserInfo) []string {
+	lower := strings.ToLower(password)
+	tokens := strings.Fields(user.Nombre + " " + user.Apellido)
+	if local, _, ok := strings.Cut(user.Correo, "@"); ok {
+		tokens = append(tokens, local)
+	}
+	for _, token := range tokens {
+		token = strings.ToLower(token)
+		if utf8.RuneCountInString(token) >= minPersonalTokenLength && strings.Contains(lower, token) {
+			return []string{"La contraseña no puede contener tu nombre, apellido o correo"}
+		}
+	}
+	return nil
+}
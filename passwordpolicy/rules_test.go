@@ -0,0 +1,98 @@
+package passwordpolicy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMinLengthCountsRunes(t *testing.T) {
+	rule := MinLength{Min: 6}
+	for password, ok := range map[string]bool{
+		"abcdef": true,
+		"abcde":  false,
+		"ñandú!": true, // 6 runas, 8 bytes
+		"ñandú":  false,
+	} {
+		if got := len(rule.Check(password, UserInfo{})) == 0; got != ok {
+			t.Errorf("MinLength(%q) válida = %v, se esperaba %v", password, got, ok)
+		}
+	}
+}
+
+func TestCharClassesReportsEachMissingClass(t *testing.T) {
+	rule := CharClasses{Required: []CharClass{ClassLower, ClassUpper, ClassDigit, ClassSymbol}}
+	if v := rule.Check("Abc1 ", UserInfo{}); len(v) != 0 {
+		t.Errorf("el espacio debe contar como símbolo: %v", v)
+	}
+	got := rule.Check("abcdef", UserInfo{})
+	want := []string{charClassMessages[ClassUpper], charClassMessages[ClassDigit], charClassMessages[ClassSymbol]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check(\"abcdef\") = %v, se esperaba %v", got, want)
+	}
+	if _, err := ParseCharClass(" Digit "); err != nil {
+		t.Errorf("ParseCharClass debe ignorar espacios y mayúsculas: %v", err)
+	}
+	if _, err := ParseCharClass("emoji"); err == nil {
+		t.Error("ParseCharClass aceptó una clase desconocida")
+	}
+}
+
+func TestMaxRepeated(t *testing.T) {
+	rule := MaxRepeated{Max: 3}
+	for password, ok := range map[string]bool{
+		"aaab-aaab": true,
+		"xaaaax":    false,
+		"ñññ1ñññ":   true,
+		"ññññ":      false,
+	} {
+		if got := len(rule.Check(password, UserInfo{})) == 0; got != ok {
+			t.Errorf("MaxRepeated(%q) válida = %v, se esperaba %v", password, got, ok)
+		}
+	}
+}
+
+func TestNoPersonalInfo(t *testing.T) {
+	user := UserInfo{Nombre: "Li María", Apellido: "López", Correo: "alopez@hospital.com"}
+	for password, ok := range map[string]bool{
+		"Clave-MARÍA-2024":    false,
+		"lópez!Segura99":      false,
+		"xALOPEZx-2024":       false,
+		"li-9-clave-segura":   true, // "Li" es demasiado corto para rechazarse
+		"hospital-2024-clave": true, // el dominio del correo no es personal
+	} {
+		if got := len(NoPersonalInfo{}.Check(password, user)) == 0; got != ok {
+			t.Errorf("NoPersonalInfo(%q) válida = %v, se esperaba %v", password, got, ok)
+		}
+	}
+}
+
+func TestPolicyReturnsAllViolations(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		MinLength{Min: 12},
+		CharClasses{Required: []CharClass{ClassDigit, ClassSymbol}},
+		MaxRepeated{Max: 2},
+		NoPersonalInfo{},
+	}}
+	got := policy.Validate("lópezzz", UserInfo{Apellido: "López"})
+	if len(got) != 5 {
+		t.Fatalf("se esperaban 5 infracciones (longitud, dígito, símbolo, repetición, datos personales), hay %d: %v", len(got), got)
+	}
+	if v := policy.Validate("Clave-Segura-2024", UserInfo{Apellido: "López"}); len(v) != 0 {
+		t.Errorf("contraseña válida rechazada: %v", v)
+	}
+}
+
+func TestMaxLengthCountsBytes(t *testing.T) {
+	rule := MaxLength{Max: 72}
+	for password, ok := range map[string]bool{
+		strings.Repeat("a", 72): true,
+		strings.Repeat("a", 73): false,
+		strings.Repeat("ñ", 36): true, // 72 bytes
+		strings.Repeat("ñ", 37): false,
+	} {
+		if got := len(rule.Check(password, UserInfo{})) == 0; got != ok {
+			t.Errorf("MaxLength(%d bytes) válida = %v, se esperaba %v", len(password), got, ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package policy define qué puede hacer cada rol. Los permisos tienen la forma recurso:acción
+// y se exigen por ruta con middleware.RequirePermission; cambiar quién puede hacer qué solo
+// requiere editar rolePermissions.
+package policy
+
+import "sort"
+
+// Permission es una acción sobre un recurso, p. ej. "citas:aceptar".
+type Permission string
+
+const (
+	CitasCrear    Permission = "citas:crear"
+	CitasLeer     Permission = "citas:leer"
+	CitasCancelar Permission = "citas:cancelar"
+	CitasAceptar  Permission = "citas:aceptar"
+	// CitasLeerPropias y ExpedientesLeerPropio cubren las rutas que listan los datos del propio paciente;
+	// CitasLeer y ExpedientesLeer son las lecturas por paciente, sujetas a relación de atención
+	CitasLeerPropias Permission = "citas:leer_propias"
+
+	ExpedientesCrear      Permission = "expedientes:crear"
+	ExpedientesLeer       Permission = "expedientes:leer"
+	ExpedientesEditar     Permission = "expedientes:editar"
+	ExpedientesEliminar   Permission = "expedientes:eliminar"
+	ExpedientesLeerPropio Permission = "expedientes:leer_propio"
+	// ExpedientesEmergencia permite abrir un acceso de emergencia al expediente sin relación de atención
+	ExpedientesEmergencia Permission = "expedientes:emergencia"
+
+	ConsultoriosLeer      Permission = "consultorios:leer"
+	ConsultoriosGestionar Permission = "consultorios:gestionar"
+	HorariosLeer          Permission = "horarios:leer"
+	HorariosGestionar     Permission = "horarios:gestionar"
+
+	ConsultasAsignar Permission = "consultas:asignar"
+	ConsultasLeer    Permission = "consultas:leer"
+
+	UsuariosLeer          Permission = "usuarios:leer"
+	UsuariosGestionar     Permission = "usuarios:gestionar"
+	InvitacionesGestionar Permission = "invitaciones:gestionar"
+	ClavesGestionar       Permission = "claves:gestionar"
+	EmergenciasRevisar    Permission = "emergencias:revisar"
+
+	CuentaExportar Permission = "cuenta:exportar"
+	CuentaCerrar   Permission = "cuenta:cerrar"
+)
+
+// rolePermissions es la única fuente de verdad de los permisos de cada rol.
+var rolePermissions = map[string][]Permission{
+	"Paciente": {
+		CitasCrear, CitasLeer, CitasLeerPropias, CitasCancelar,
+		ExpedientesCrear, ExpedientesLeer, ExpedientesLeerPropio, ExpedientesEditar, ExpedientesEliminar,
+		ConsultasLeer,
+		CuentaExportar, CuentaCerrar,
+	},
+	// Las lecturas de datos de pacientes del personal se limitan además por relación (middleware.RequirePatientAccess)
+	"Medico": {
+		CitasAceptar, CitasLeer,
+		ExpedientesLeer, ConsultasAsignar, ConsultasLeer, ExpedientesEmergencia,
+		ConsultoriosLeer, ConsultoriosGestionar,
+		HorariosLeer, HorariosGestionar,
+	},
+	// La enfermera no se asigna consultas a sí misma: la asignación le da acceso al paciente
+	"Enfermero": {
+		ConsultasLeer,
+		CitasLeer, ExpedientesLeer,
+	},
+	"Administrador": {
+		UsuariosLeer, UsuariosGestionar, InvitacionesGestionar, ClavesGestionar,
+		EmergenciasRevisar, ConsultasAsignar,
+	},
+}
+
+// apiKeyScopes son los permisos que pueden concederse a una clave de API. Solo lecturas: la
+// gestión de usuarios, invitaciones y claves queda reservada a personas.
+var apiKeyScopes = map[Permission]bool{
+	CitasLeer:       true,
+	ExpedientesLeer: true,
+	ConsultasLeer:   true,
+	UsuariosLeer:    true,
+}
+
+var grants = buildGrants(rolePermissions)
+
+func buildGrants(m map[string][]Permission) map[string]map[Permission]bool {
+	g := make(map[string]map[Permission]bool, len(m))
+	for role, perms := range m {
+		g[role] = make(map[Permission]bool, len(perms))
+		for _, p := range perms {
+			g[role][p] = true
+		}
+	}
+	return g
+}
+
+// Allowed indica si role tiene perm. Un rol desconocido no tiene ningún permiso.
+func Allowed(role string, perm Permission) bool {
+	return grants[role][perm]
+}
+
+// PermissionsFor devuelve los permisos de role ordenados alfabéticamente.
+func PermissionsFor(role string) []Permission {
+	perms := append([]Permission(nil), rolePermissions[role]...)
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
+
+// APIKeyScope indica si perm puede concederse a una clave de API.
+func APIKeyScope(perm Permission) bool {
+	return apiKeyScopes[perm]
+}
@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{"Paciente", CitasCrear, true},
+		{"Paciente", CitasLeerPropias, true},
+		{"Paciente", ExpedientesLeerPropio, true},
+		{"Paciente", CitasAceptar, false},
+		{"Paciente", UsuariosGestionar, false},
+		{"Medico", CitasAceptar, true},
+		{"Medico", ExpedientesEmergencia, true},
+		{"Medico", ExpedientesEditar, false},
+		{"Medico", CitasLeerPropias, false},
+		{"Medico", ExpedientesLeerPropio, false},
+		{"Medico", ConsultasAsignar, true},
+		{"Enfermero", ConsultasAsignar, false},
+		{"Enfermero", ExpedientesEmergencia, false},
+		{"Enfermero", CitasLeerPropias, false},
+		{"Enfermero", ExpedientesLeerPropio, false},
+		{"Administrador", UsuariosGestionar, true},
+		{"Administrador", EmergenciasRevisar, true},
+		{"Administrador", ConsultasAsignar, true},
+		{"Administrador", ExpedientesLeer, false},
+	} {
+		if got := Allowed(tc.role, tc.perm); got != tc.want {
+			t.Errorf("Allowed(%q, %q) = %v, se esperaba %v", tc.role, tc.perm, got, tc.want)
+		}
+	}
+}
+
+func TestUnknownRoleHasNoPermissions(t *testing.T) {
+	for _, role := range []string{"", "Desconocido", "paciente", "ADMINISTRADOR"} {
+		for _, perms := range rolePermissions {
+			for _, perm := range perms {
+				if Allowed(role, perm) {
+					t.Errorf("Allowed(%q, %q) = true para un rol desconocido", role, perm)
+				}
+			}
+		}
+		if perms := PermissionsFor(role); len(perms) != 0 {
+			t.Errorf("PermissionsFor(%q) = %v, se esperaba vacío", role, perms)
+		}
+	}
+}
+
+func TestPermissionsFor(t *testing.T) {
+	for role, granted := range rolePermissions {
+		perms := PermissionsFor(role)
+		if len(perms) != len(granted) {
+			t.Fatalf("PermissionsFor(%q) devolvió %d permisos, el rol tiene %d", role, len(perms), len(granted))
+		}
+		if !sort.SliceIsSorted(perms, func(i, j int) bool { return perms[i] < perms[j] }) {
+			t.Errorf("PermissionsFor(%q) = %v no está ordenado", role, perms)
+		}
+		for _, perm := range perms {
+			if !Allowed(role, perm) {
+				t.Errorf("PermissionsFor(%q) incluye %q pero Allowed lo niega", role, perm)
+			}
+		}
+	}
+
+	// El resultado es una copia: modificarlo no cambia los permisos del rol
+	perms := PermissionsFor("Paciente")
+	perms[0] = UsuariosGestionar
+	if Allowed("Paciente", UsuariosGestionar) || PermissionsFor("Paciente")[0] == UsuariosGestionar {
+		t.Error("modificar el resultado de PermissionsFor alteró la política")
+	}
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"hospitalaria/handlers"
+	"hospitalaria/middleware"
+	"hospitalaria/policy"
+)
+
+func SetupAdminRoutes(app *fiber.App) {
+	// Las claves de API solo pueden obtener scopes de lectura (policy.APIKeyScope)
+	admin := app.Group("/admin", middleware.JWTOrAPIKey())
+	admin.Get("/users", middleware.RequirePermission(policy.UsuariosLeer), handlers.ListUsers)
+	admin.Get("/users/:id", middleware.RequirePermission(policy.UsuariosLeer), handlers.GetUser)
+	admin.Put("/users/:id/role", middleware.RequirePermission(policy.UsuariosGestionar), handlers.ChangeUserRole)
+	admin.Post("/users/:id/deactivate", middleware.RequirePermission(policy.UsuariosGestionar), handlers.DeactivateUser)
+	admin.Post("/users/:id/reactivate", middleware.RequirePermission(policy.UsuariosGestionar), handlers.ReactivateUser)
+	admin.Post("/users/:id/unlock", middleware.RequirePermission(policy.UsuariosGestionar), handlers.UnlockAccount)
+	admin.Post("/users/:id/totp-reset", middleware.RequirePermission(policy.UsuariosGestionar), handlers.ResetTOTP)
+	admin.Post("/invitations", middleware.RequirePermission(policy.InvitacionesGestionar), handlers.CreateInvitation)
+	admin.Delete("/invitations/:id", middleware.RequirePermission(policy.InvitacionesGestionar), handlers.RevokeInvitation)
+	admin.Post("/api-keys", middleware.RequirePermission(policy.ClavesGestionar), handlers.CreateAPIKey)
+	admin.Get("/api-keys", middleware.RequirePermission(policy.ClavesGestionar), handlers.ListAPIKeys)
+	admin.Delete("/api-keys/:id", middleware.RequirePermission(policy.ClavesGestionar), handlers.RevokeAPIKey)
+	admin.Get("/break-glass", middleware.RequirePermission(policy.EmergenciasRevisar), handlers.ListBreakGlassAccess)
+}
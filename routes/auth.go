@@ -3,11 +3,35 @@ package routes
 import (
 	"github.com/gofiber/fiber/v2"
 	"hospitalaria/handlers"
+	"hospitalaria/middleware"
 )
 
 func SetupAuthRoutes(app *fiber.App) {
 	app.Post("/register", handlers.CreateUser)
 	app.Post("/login", handlers.Login)
 	app.Post("/refresh-token", handlers.RefreshToken) // Nuevo endpoint para refresh token
-	app.Get("/profile", handlers.GetUserProfile)
+	app.Get("/profile", middleware.JWTProtected(), handlers.GetUserProfile)
+	app.Patch("/profile", middleware.JWTProtected(), handlers.UpdateUserProfile)
+	app.Post("/email/verify", handlers.VerifyEmail)
+	app.Post("/email/verify/resend", handlers.ResendEmailVerification)
+	app.Post("/password/forgot", handlers.ForgotPassword)
+	app.Post("/password/reset", handlers.ResetPassword)
+	app.Put("/password", middleware.JWTProtected(), handlers.ChangePassword)
+	app.Post("/logout", middleware.JWTProtected(), handlers.Logout)
+	app.Post("/logout-all", middleware.JWTProtected(), handlers.LogoutAll)
+	app.Get("/sessions", middleware.JWTProtected(), handlers.GetSessions)
+	app.Delete("/sessions/:id", middleware.JWTProtected(), handlers.DeleteSession)
+	app.Get("/.well-known/jwks.json", handlers.GetJWKS)
+	app.Post("/mfa/recovery-codes", middleware.JWTProtected(), handlers.RegenerateRecoveryCodes)
+	app.Post("/mfa/totp/enroll", middleware.JWTProtected(), handlers.StartTOTPEnrollment)
+	app.Post("/mfa/totp/confirm", middleware.JWTProtected(), handlers.ConfirmTOTPEnrollment)
+	app.Post("/login/totp-enrollment", handlers.CompleteTOTPEnrollment)
+	app.Post("/login/webauthn", handlers.FinishWebAuthnLogin)
+	app.Get("/login/oidc", handlers.StartOIDCLogin)
+	app.Post("/login/oidc/callback", handlers.FinishOIDCLogin)
+	app.Post("/mfa/webauthn/register/begin", middleware.JWTProtected(), handlers.BeginWebAuthnRegistration)
+	app.Post("/mfa/webauthn/register/finish", middleware.JWTProtected(), handlers.FinishWebAuthnRegistration)
+	app.Get("/mfa/webauthn/credentials", middleware.JWTProtected(), handlers.GetWebAuthnCredentials)
+	app.Delete("/mfa/webauthn/credentials/:id", middleware.JWTProtected(), handlers.DeleteWebAuthnCredential)
+	app.Post("/invitations/accept", handlers.AcceptInvitation)
 }
\ No newline at end of file
@@ -4,8 +4,9 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"hospitalaria/handlers/enfermeras"
 	"hospitalaria/middleware"
+	"hospitalaria/policy"
 )
 
 func SetupEnfermeraRoutes(app *fiber.App) {
-	app.Post("/consultas", middleware.JWTProtected(), enfermeras.AssignConsulta)
+	app.Post("/consultas", middleware.JWTProtected(), middleware.RequirePermission(policy.ConsultasAsignar), enfermeras.AssignConsulta)
 }
\ No newline at end of file
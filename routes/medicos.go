@@ -4,16 +4,17 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"hospitalaria/handlers/medicos"
 	"hospitalaria/middleware"
+	"hospitalaria/policy"
 )
 
 func SetupMedicoRoutes(app *fiber.App) {
-	app.Put("/appointments", middleware.JWTProtected(), medicos.UpdateAppointment)
-	app.Post("/consultorios", middleware.JWTProtected(), medicos.CreateConsultorio)
-	app.Get("/consultorios", middleware.JWTProtected(), medicos.GetConsultorios)
-	app.Put("/consultorios", middleware.JWTProtected(), medicos.UpdateConsultorio)
-	app.Delete("/consultorios", middleware.JWTProtected(), medicos.DeleteConsultorio)
-	app.Post("/horarios", middleware.JWTProtected(), medicos.CreateHorario)
-	app.Get("/horarios", middleware.JWTProtected(), medicos.GetHorarios)
-	app.Put("/horarios", middleware.JWTProtected(), medicos.UpdateHorario)
-	app.Delete("/horarios", middleware.JWTProtected(), medicos.DeleteHorario)
+	app.Put("/appointments", middleware.JWTProtected(), middleware.RequirePermission(policy.CitasAceptar), medicos.UpdateAppointment)
+	app.Post("/consultorios", middleware.JWTProtected(), middleware.RequirePermission(policy.ConsultoriosGestionar), medicos.CreateConsultorio)
+	app.Get("/consultorios", middleware.JWTProtected(), middleware.RequirePermission(policy.ConsultoriosLeer), medicos.GetConsultorios)
+	app.Put("/consultorios", middleware.JWTProtected(), middleware.RequirePermission(policy.ConsultoriosGestionar), medicos.UpdateConsultorio)
+	app.Delete("/consultorios", middleware.JWTProtected(), middleware.RequirePermission(policy.ConsultoriosGestionar), medicos.DeleteConsultorio)
+	app.Post("/horarios", middleware.JWTProtected(), middleware.RequirePermission(policy.HorariosGestionar), medicos.CreateHorario)
+	app.Get("/horarios", middleware.JWTProtected(), middleware.RequirePermission(policy.HorariosLeer), medicos.GetHorarios)
+	app.Put("/horarios", middleware.JWTProtected(), middleware.RequirePermission(policy.HorariosGestionar), medicos.UpdateHorario)
+	app.Delete("/horarios", middleware.JWTProtected(), middleware.RequirePermission(policy.HorariosGestionar), medicos.DeleteHorario)
 }
\ No newline at end of file
@@ -2,16 +2,24 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"hospitalaria/handlers"
 	"hospitalaria/handlers/pacientes"
 	"hospitalaria/middleware"
+	"hospitalaria/policy"
 )
 
 func SetupPacienteRoutes(app *fiber.App) {
-	app.Post("/appointments", middleware.JWTProtected(), pacientes.CreateAppointment)
-	app.Get("/appointments", middleware.JWTProtected(), pacientes.GetAppointments)
-	app.Delete("/appointments", middleware.JWTProtected(), pacientes.DeleteAppointment)
-	app.Post("/expedientes", middleware.JWTProtected(), pacientes.CreateExpediente)
-	app.Get("/expedientes", middleware.JWTProtected(), pacientes.GetExpedientes)
-	app.Put("/expedientes", middleware.JWTProtected(), pacientes.UpdateExpediente)
-	app.Delete("/expedientes", middleware.JWTProtected(), pacientes.DeleteExpediente)
+	app.Post("/appointments", middleware.JWTProtected(), middleware.RequirePermission(policy.CitasCrear), pacientes.CreateAppointment)
+	app.Get("/appointments", middleware.JWTProtected(), middleware.RequirePermission(policy.CitasLeerPropias), pacientes.GetAppointments)
+	app.Delete("/appointments", middleware.JWTProtected(), middleware.RequirePermission(policy.CitasCancelar), pacientes.DeleteAppointment)
+	app.Post("/expedientes", middleware.JWTProtected(), middleware.RequirePermission(policy.ExpedientesCrear), pacientes.CreateExpediente)
+	app.Get("/expedientes", middleware.JWTProtected(), middleware.RequirePermission(policy.ExpedientesLeerPropio), pacientes.GetExpedientes)
+	app.Put("/expedientes", middleware.JWTProtected(), middleware.RequirePermission(policy.ExpedientesEditar), pacientes.UpdateExpediente)
+	app.Delete("/expedientes", middleware.JWTProtected(), middleware.RequirePermission(policy.ExpedientesEliminar), pacientes.DeleteExpediente)
+	app.Get("/pacientes/:id/expediente", middleware.JWTOrAPIKey(), middleware.RequirePermission(policy.ExpedientesLeer), middleware.RequireExpedienteAccess(), pacientes.GetPatientExpediente)
+	app.Post("/pacientes/:id/break-glass", middleware.JWTProtected(), middleware.RequirePermission(policy.ExpedientesEmergencia), handlers.BreakGlass)
+	app.Get("/pacientes/:id/citas", middleware.JWTOrAPIKey(), middleware.RequirePermission(policy.CitasLeer), middleware.RequirePatientAccess(), pacientes.GetPatientAppointments)
+	app.Get("/pacientes/:id/consultas", middleware.JWTOrAPIKey(), middleware.RequirePermission(policy.ConsultasLeer), middleware.RequirePatientAccess(), pacientes.GetPatientConsultas)
+	app.Get("/account/export", middleware.JWTProtected(), middleware.RequirePermission(policy.CuentaExportar), handlers.ExportAccountData)
+	app.Post("/account/close", middleware.JWTProtected(), middleware.RequirePermission(policy.CuentaCerrar), handlers.CloseAccount)
 }
\ No newline at end of file
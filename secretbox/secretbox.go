@@ -0,0 +1,138 @@
+// Package secretbox cifra valores pequeños (p. ej. secretos TOTP) con AES-256-GCM para guardarlos
+// en la base de datos. El resultado tiene la forma enc:v2:<kid>:<base64(nonce|cifrado)>, de modo que
+// cada valor indica con qué clave se cifró y las claves pueden rotarse sin descifrar todo de golpe.
+//
+// En v2 el AAD de cada valor incluye a qué registro pertenece (p. ej. el id del usuario), así que un
+// valor copiado a otra fila no se descifra. Los valores enc:v1: usaban un AAD común a toda la columna:
+// el llamador los reconoce con IsLegacy y NeedsReencrypt los marca para volver a cifrarlos.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	prefix       = "enc:v2:"
+	legacyPrefix = "enc:v1:"
+)
+
+var (
+	// ErrUnknownKey indica un valor cifrado con una clave que ya no está en el llavero.
+	ErrUnknownKey = errors.New("clave de cifrado desconocida")
+	// ErrMalformed indica un valor con prefijo de cifrado pero contenido inválido.
+	ErrMalformed = errors.New("valor cifrado mal formado")
+)
+
+// Keyring guarda las claves AES-256 por id; Active es la que cifra los valores nuevos.
+type Keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// NewKeyring crea un llavero a partir de claves de 32 bytes indexadas por id.
+func NewKeyring(keys map[string][]byte, active string) (*Keyring, error) {
+	k := &Keyring{active: active, aeads: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("id de clave inválido: %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("la clave %q debe tener 32 bytes, tiene %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+	}
+	if _, ok := k.aeads[active]; !ok {
+		return nil, fmt.Errorf("la clave activa %q no está en el llavero", active)
+	}
+	return k, nil
+}
+
+// Active devuelve el id de la clave con la que se cifran los valores nuevos.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// Encrypt cifra plaintext con la clave activa. aad liga el valor a su uso (no se guarda).
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return prefix + k.active + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt descifra un valor producido por Encrypt (o uno enc:v1: con su AAD común). Un valor sin
+// prefijo de cifrado se considera texto plano anterior al cifrado y se devuelve tal cual, para poder
+// migrar los datos existentes.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	kid, data, encrypted, err := parse(value)
+	if err != nil || !encrypted {
+		return value, err
+	}
+	aead, ok := k.aeads[kid]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	if len(data) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencrypt indica si value está en texto plano, en formato v1 o cifrado con una clave distinta de la activa.
+func (k *Keyring) NeedsReencrypt(value string) bool {
+	kid, _, encrypted, err := parse(value)
+	return err != nil || !encrypted || IsLegacy(value) || kid != k.active
+}
+
+// IsLegacy indica si value se cifró en formato v1, con un AAD común en lugar de uno por registro.
+func IsLegacy(value string) bool {
+	return strings.HasPrefix(value, legacyPrefix)
+}
+
+// EncryptedWith devuelve el prefijo que comparten los valores cifrados con la clave kid,
+// útil para buscarlos en SQL con LIKE.
+func EncryptedWith(kid string) string {
+	return prefix + kid + ":"
+}
+
+func parse(value string) (kid string, data []byte, encrypted bool, err error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(value, prefix):
+		rest = value[len(prefix):]
+	case strings.HasPrefix(value, legacyPrefix):
+		rest = value[len(legacyPrefix):]
+	default:
+		return "", nil, false, nil
+	}
+	i := strings.IndexByte(rest, ':')
+	if i <= 0 {
+		return "", nil, true, ErrMalformed
+	}
+	data, err = base64.RawStdEncoding.DecodeString(rest[i+1:])
+	if err != nil {
+		return "", nil, true, ErrMalformed
+	}
+	return rest[:i], data, true, nil
+}
@@ -0,0 +1,169 @@
+package secretbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	k, err := NewKeyring(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	k := testKeyring(t, "2025-08", "2025-08")
+	sealed, err := k.Encrypt("JBSWY3DPEHPK3PXP", "usuarios.totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, EncryptedWith("2025-08")) || strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("valor cifrado inesperado: %s", sealed)
+	}
+	again, _ := k.Encrypt("JBSWY3DPEHPK3PXP", "usuarios.totp_secret:1")
+	if again == sealed {
+		t.Error("dos cifrados del mismo valor deben usar nonces distintos")
+	}
+	plain, err := k.Decrypt(sealed, "usuarios.totp_secret:1")
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Decrypt = %q, %v", plain, err)
+	}
+	if k.NeedsReencrypt(sealed) || IsLegacy(sealed) {
+		t.Error("un valor v2 con la clave activa no necesita volver a cifrarse")
+	}
+}
+
+func TestDecryptRejectsOtherAAD(t *testing.T) {
+	k := testKeyring(t, "a", "a")
+	sealed, err := k.Encrypt("secreto", "usuarios.totp_secret:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Otro usuario (valor copiado a otra fila) u otra columna
+	for _, aad := range []string{"usuarios.totp_secret:2", "usuarios.totp_secret", "otra_tabla.secreto:1", ""} {
+		if _, err := k.Decrypt(sealed, aad); err == nil {
+			t.Errorf("se descifró con AAD %q", aad)
+		}
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	old := testKeyring(t, "retirada", "retirada")
+	sealed, err := old.Encrypt("secreto", "aad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := testKeyring(t, "nueva", "nueva")
+	if _, err := k.Decrypt(sealed, "aad"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, se esperaba ErrUnknownKey", err)
+	}
+}
+
+func TestDecryptTamperedOrMalformed(t *testing.T) {
+	k := testKeyring(t, "a", "a")
+	sealed, err := k.Encrypt("secreto", "aad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(sealed, EncryptedWith("a")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0x01
+	tampered := EncryptedWith("a") + base64.RawStdEncoding.EncodeToString(data)
+	if _, err := k.Decrypt(tampered, "aad"); err == nil {
+		t.Error("se descifró un valor alterado")
+	}
+
+	for _, value := range []string{
+		"enc:v2:a",           // sin datos
+		"enc:v2::" + "AAAA",  // sin kid
+		"enc:v2:a:%%%",       // base64 inválido
+		"enc:v2:a:" + "AAAA", // más corto que el nonce
+	} {
+		if _, err := k.Decrypt(value, "aad"); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Decrypt(%q): err = %v, se esperaba ErrMalformed", value, err)
+		}
+	}
+}
+
+func TestPlaintextPassThrough(t *testing.T) {
+	k := testKeyring(t, "a", "a")
+	plain, err := k.Decrypt("JBSWY3DPEHPK3PXP", "aad")
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Decrypt de texto plano = %q, %v", plain, err)
+	}
+	if !k.NeedsReencrypt("JBSWY3DPEHPK3PXP") {
+		t.Error("un valor en texto plano debe volver a cifrarse")
+	}
+}
+
+func TestLegacyFormat(t *testing.T) {
+	k := testKeyring(t, "a", "a")
+	sealed, err := k.Encrypt("secreto", "usuarios.totp_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// v1 y v2 solo difieren en el AAD que usa el llamador
+	legacy := "enc:v1:" + strings.TrimPrefix(sealed, "enc:v2:")
+	if !IsLegacy(legacy) || IsLegacy(sealed) {
+		t.Fatal("IsLegacy no distingue v1 de v2")
+	}
+	plain, err := k.Decrypt(legacy, "usuarios.totp_secret")
+	if err != nil || plain != "secreto" {
+		t.Fatalf("Decrypt de v1 = %q, %v", plain, err)
+	}
+	if !k.NeedsReencrypt(legacy) {
+		t.Error("un valor v1 con la clave activa debe volver a cifrarse")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := testKeyring(t, "2025-01", "2025-01")
+	sealed, err := old.Encrypt("secreto", "aad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := testKeyring(t, "2025-08", "2025-01", "2025-08")
+	if !rotated.NeedsReencrypt(sealed) {
+		t.Fatal("un valor de la clave anterior debe volver a cifrarse")
+	}
+	plain, err := rotated.Decrypt(sealed, "aad")
+	if err != nil || plain != "secreto" {
+		t.Fatalf("Decrypt con la clave anterior = %q, %v", plain, err)
+	}
+	resealed, err := rotated.Encrypt(plain, "aad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resealed, EncryptedWith("2025-08")) || rotated.NeedsReencrypt(resealed) {
+		t.Fatalf("valor recifrado inesperado: %s", resealed)
+	}
+}
+
+func TestNewKeyringValidation(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for name, tc := range map[string]struct {
+		keys   map[string][]byte
+		active string
+	}{
+		"id vacío":              {map[string][]byte{"": key}, ""},
+		"id con dos puntos":     {map[string][]byte{"a:b": key}, "a:b"},
+		"clave corta":           {map[string][]byte{"a": key[:16]}, "a"},
+		"activa fuera de lista": {map[string][]byte{"a": key}, "b"},
+	} {
+		if _, err := NewKeyring(tc.keys, tc.active); err == nil {
+			t.Errorf("%s: se aceptó el llavero", name)
+		}
+	}
+}
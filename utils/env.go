@@ -3,6 +3,7 @@ package utils
 import (
 	"log"
 	"os"
+	"strconv"
 	"github.com/joho/godotenv"
 )
 
@@ -14,4 +15,13 @@ func LoadEnv() {
 	if err != nil {
 		log.Fatal("Error cargando archivo .env")
 	}
-}
\ No newline at end of file
+}
+
+// GetEnvInt lee una variable de entorno entera, usando def si no está definida o no es válida.
+func GetEnvInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
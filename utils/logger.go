@@ -1,13 +1,24 @@
 package utils
 
 import (
+	"bufio"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Los saltos de línea del detalle se escapan: cada entrada ocupa una línea y un correo o motivo
+// con "\n" no puede añadir entradas falsas al registro (ni a la exportación de datos).
+var (
+	logEscaper   = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`)
+	logUnescaper = strings.NewReplacer(`\\`, `\`, `\r`, "\r", `\n`, "\n")
+)
+
 func LogAction(userID int, action, status, details string) {
-	logEntry := fmt.Sprintf("%d,%s,%s,%s,%s\n", userID, action, time.Now().Format(time.RFC3339), status, details)
+	logEntry := fmt.Sprintf("%d,%s,%s,%s,%s\n", userID, action, time.Now().Format(time.RFC3339), status, logEscaper.Replace(details))
 	file, err := os.OpenFile("utils/app.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return
@@ -15,3 +26,52 @@ func LogAction(userID int, action, status, details string) {
 	defer file.Close()
 	file.WriteString(logEntry)
 }
+
+// alertMarker inicia el detalle de las entradas de LogAlert, para distinguirlas en app.log.
+const alertMarker = "prioridad=alta; "
+
+// LogAlert registra un evento de auditoría de alta prioridad: la entrada en app.log lleva
+// alertMarker y además se escribe en el log del servidor con el prefijo ALERTA, para que lo
+// recoja la monitorización.
+func LogAlert(userID int, action, status, details string) {
+	LogAction(userID, action, status, alertMarker+details)
+	log.Printf("ALERTA usuario=%d accion=%s estado=%s: %s", userID, action, status, logEscaper.Replace(details))
+}
+
+// LogEntry es una línea del registro de auditoría.
+type LogEntry struct {
+	Accion  string `json:"accion"`
+	Fecha   string `json:"fecha"`
+	Estado  string `json:"estado"`
+	Detalle string `json:"detalle"`
+}
+
+// UserLogEntries devuelve, en orden, las entradas del registro de auditoría de userID.
+func UserLogEntries(userID int) ([]LogEntry, error) {
+	file, err := os.Open("utils/app.log")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prefix := strconv.Itoa(userID) + ","
+	var entries []LogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		// El detalle puede contener comas: solo se separan los cuatro primeros campos
+		fields := strings.SplitN(line, ",", 5)
+		if len(fields) < 5 {
+			continue
+		}
+		entries = append(entries, LogEntry{Accion: fields[1], Fecha: fields[2], Estado: fields[3], Detalle: logUnescaper.Replace(fields[4])})
+	}
+	return entries, scanner.Err()
+}
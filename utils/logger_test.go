@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogActionEscapesLineBreaks(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("utils", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un detalle con saltos de línea intenta añadir una entrada falsa a nombre del usuario 7
+	forged := "correo@hospital.com\n7,login,2025-01-01T00:00:00Z,exitoso,entrada falsa\r\nruta C:\\datos"
+	LogAction(7, "update_profile", "fallido", forged)
+	LogAction(7, "logout", "exitoso", "Sesión cerrada")
+
+	data, err := os.ReadFile("utils/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); len(lines) != 2 {
+		t.Fatalf("el registro tiene %d líneas, se esperaban 2:\n%s", len(lines), data)
+	}
+
+	entries, err := UserLogEntries(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Accion != "update_profile" || entries[1].Accion != "logout" {
+		t.Fatalf("entradas = %+v", entries)
+	}
+	if entries[0].Detalle != forged {
+		t.Fatalf("detalle = %q, se esperaba %q", entries[0].Detalle, forged)
+	}
+}
+
+func TestLogAlertMarksAuditEntry(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("utils", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	LogAction(3, "get_expediente", "exitoso", "Lectura normal")
+	LogAlert(3, "break_glass", "exitoso", "Acceso de emergencia #1")
+
+	entries, err := UserLogEntries(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entradas = %+v", entries)
+	}
+	if strings.HasPrefix(entries[0].Detalle, alertMarker) {
+		t.Errorf("la entrada normal lleva la marca de alerta: %q", entries[0].Detalle)
+	}
+	if entries[1].Detalle != alertMarker+"Acceso de emergencia #1" {
+		t.Errorf("detalle de la alerta = %q", entries[1].Detalle)
+	}
+}